@@ -0,0 +1,240 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Command jsonnet_impact prints the jsonnet targets affected by a change to
+// the given workspace files, so CI can build and test only those.
+//
+// Changed files are read from the arguments or, if there are none, from the
+// standard input, one per line:
+//
+//	git diff --name-only origin/master | jsonnet_impact -kind=jsonnet_to_json
+//
+// A target is affected when one of its sources is a changed file, or imports
+// a changed file directly or transitively. Targets are read from the existing
+// BUILD files of the workspace, and imports are resolved with the directives
+// of their directory, as Gazelle does.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	gzwalk "github.com/bazelbuild/bazel-gazelle/walk"
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/graph"
+)
+
+// srcAttrs maps the kinds of the targets to report to their source attributes.
+// Tests are also affected by a change to their golden file.
+var srcAttrs = map[string][]string{
+	"jsonnet_library":      {"srcs"},
+	"jsonnet_to_json":      {"src"},
+	"jsonnet_to_json_test": {"src", "golden"},
+}
+
+func main() {
+	log.SetPrefix("jsonnet_impact: ")
+	log.SetFlags(0)
+
+	repoRoot := flag.String("repo_root", "", "path to the root of the workspace. Defaults to $BUILD_WORKSPACE_DIRECTORY or the current directory")
+	kind := flag.String("kind", "", "only print targets of this kind, e.g. jsonnet_to_json")
+	flag.Parse()
+
+	if *kind != "" && srcAttrs[*kind] == nil {
+		log.Fatalf("-kind: unknown kind %q", *kind)
+	}
+	root, err := findRoot(*repoRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	changed := flag.Args()
+	if len(changed) == 0 {
+		if changed, err = readLines(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i, file := range changed {
+		if changed[i], err = relPath(root, file); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	labels, err := affected(root, changed, *kind)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, l := range labels {
+		fmt.Println(l)
+	}
+}
+
+// affected returns the sorted labels of the targets of the given kind, or of any
+// kind, affected by a change to the given workspace-relative files.
+func affected(root string, changed []string, kind string) ([]string, error) {
+	g, targets, err := walk(root)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, file := range append(g.Dependents(changed...), changed...) {
+		for _, t := range targets[file] {
+			if kind == "" || t.kind == kind {
+				found[t.label] = true
+			}
+		}
+	}
+	labels := make([]string, 0, len(found))
+	for l := range found {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// target is a rule of the workspace
+type target struct {
+	kind, label string
+}
+
+// walk returns the import graph of the jsonnet files in the workspace, and the
+// targets whose sources include each file, by workspace-relative path.
+//
+// The workspace is walked as Gazelle does, so the files are parsed with the
+// configuration of their directory: its directives, e.g. jsonnet_import_alias,
+// and the modules vendored by jsonnet-bundler.
+func walk(root string) (*graph.Graph, map[string][]target, error) {
+	c := config.New()
+	lang := jsonnet.NewLanguage()
+	cexts := []config.Configurer{&config.CommonConfigurer{}, &gzwalk.Configurer{}, lang}
+	fs := flag.NewFlagSet("jsonnet_impact", flag.ContinueOnError)
+	for _, cext := range cexts {
+		cext.RegisterFlags(fs, "update", c)
+	}
+	if err := fs.Parse([]string{"-repo_root", root}); err != nil {
+		return nil, nil, err
+	}
+	for _, cext := range cexts {
+		if err := cext.CheckFlags(fs, c); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Nested repositories are named after their local_repository rule
+	if f, err := rule.LoadWorkspaceFile(filepath.Join(c.RepoRoot, "WORKSPACE"), ""); err == nil {
+		if c.Repos, _, err = repo.ListRepositories(f); err != nil {
+			return nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	importer := &jsonnet.Importer{Importer: &gojsonnet.FileImporter{}}
+	g := graph.New()
+	targets := make(map[string][]target)
+	gzwalk.Walk(c, cexts, []string{c.RepoRoot}, gzwalk.VisitAllUpdateSubdirsMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		if f != nil {
+			addTargets(targets, f)
+		}
+		conf := jsonnet.GetConfig(c)
+		if conf.ShouldIgnoreFolder(rel) {
+			return
+		}
+		for _, name := range regularFiles {
+			if !conf.IsNativeImport(filepath.Ext(name)) {
+				continue
+			}
+			finfo, err := jsonnet.NewFileInfo(c, dir, rel, name, importer)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			if finfo != nil {
+				g.Add(*finfo)
+			}
+		}
+	})
+	return g, targets, nil
+}
+
+// addTargets indexes the jsonnet rules of a BUILD file by their source files
+func addTargets(targets map[string][]target, f *rule.File) {
+	pkg := f.Pkg
+	if pkg == "." {
+		pkg = ""
+	}
+	for _, r := range f.Rules {
+		attrs, found := srcAttrs[r.Kind()]
+		if !found {
+			continue
+		}
+		var srcs []string
+		for _, attr := range attrs {
+			srcs = append(srcs, r.AttrStrings(attr)...)
+			if src := r.AttrString(attr); src != "" {
+				srcs = append(srcs, src)
+			}
+		}
+		t := target{kind: r.Kind(), label: label.New("", pkg, r.Name()).String()}
+		for _, src := range srcs {
+			l, err := label.Parse(src)
+			if err != nil || l.Repo != "" {
+				continue
+			}
+			if l.Relative {
+				l.Pkg = pkg
+			}
+			file := filepath.ToSlash(filepath.Join(l.Pkg, l.Name))
+			targets[file] = append(targets[file], t)
+		}
+	}
+}
+
+// findRoot returns the absolute path of the workspace
+func findRoot(root string) (string, error) {
+	if root == "" {
+		root = os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	}
+	if root == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(root)
+}
+
+// relPath returns the path of a changed file relative to the workspace. Paths
+// are taken as already relative to it, unless absolute.
+func relPath(root, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", err
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s: file is outside of the workspace %s", path, root)
+		}
+		path = rel
+	}
+	return filepath.ToSlash(filepath.Clean(path)), nil
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, s.Err()
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const grafonnet = "vendor/github.com/grafana/grafonnet-lib/grafonnet"
+
+var workspace = map[string]string{
+	"WORKSPACE": "",
+	"BUILD.bazel": `# gazelle:jsonnet_import_alias lib shared/lib
+`,
+	"jsonnetfile.json": `{
+  "version": 1,
+  "dependencies": [
+    {
+      "source": {"git": {"remote": "https://github.com/grafana/grafonnet-lib.git", "subdir": "grafonnet"}},
+      "version": "master"
+    }
+  ]
+}`,
+	grafonnet + "/grafana.libsonnet": "{}",
+	"vendor/BUILD.bazel": `jsonnet_library(
+    name = "grafonnet",
+    srcs = ["github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet"],
+)
+`,
+	"shared/lib/util.libsonnet": "{}",
+	"shared/lib/BUILD.bazel": `jsonnet_library(
+    name = "util_library",
+    srcs = ["util.libsonnet"],
+)
+`,
+	"app/main.jsonnet":     `(import 'github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet') + (import 'lib/util.libsonnet')`,
+	"app/main.golden.json": "{}",
+	"app/other.jsonnet":    "{}",
+	"app/BUILD.bazel": `jsonnet_library(
+    name = "main_library",
+    srcs = ["main.jsonnet"],
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json_test(
+    name = "main_test",
+    src = "main.jsonnet",
+    golden = "main.golden.json",
+)
+
+jsonnet_to_json(
+    name = "other_to_json",
+    src = "other.jsonnet",
+    outs = ["other.json"],
+)
+`,
+	// Ignored folders are not parsed
+	"ignored/BUILD.bazel": `# gazelle:jsonnet_skip_folders ignored
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+`,
+	"ignored/main.jsonnet": "import '../app/other.jsonnet'",
+}
+
+func TestAffected(t *testing.T) {
+	testCases := []struct {
+		desc, kind string
+		changed    []string
+		want       []string
+	}{
+		{
+			desc:    "vendored module",
+			changed: []string{grafonnet + "/grafana.libsonnet"},
+			want:    []string{"//app:main_library", "//app:main_test", "//app:main_to_json", "//vendor:grafonnet"},
+		}, {
+			desc:    "import alias",
+			changed: []string{"shared/lib/util.libsonnet"},
+			want:    []string{"//app:main_library", "//app:main_test", "//app:main_to_json", "//shared/lib:util_library"},
+		}, {
+			desc:    "kind",
+			kind:    "jsonnet_to_json",
+			changed: []string{"shared/lib/util.libsonnet"},
+			want:    []string{"//app:main_to_json"},
+		}, {
+			desc:    "golden file",
+			changed: []string{"app/main.golden.json"},
+			want:    []string{"//app:main_test"},
+		}, {
+			desc:    "ignored folder",
+			changed: []string{"app/other.jsonnet"},
+			want:    []string{"//app:other_to_json"},
+		},
+	}
+
+	root := writeWorkspace(t, workspace)
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := affected(root, tc.changed, tc.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// writeWorkspace writes the given files, keyed by workspace-relative path, in
+// a new directory, and returns its path.
+func writeWorkspace(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
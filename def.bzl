@@ -1,7 +1,7 @@
-// Copyright 2019 VMware, Inc.
-// SPDX-License-Identifier: Apache-2.0
+# Copyright 2019 VMware, Inc.
+# SPDX-License-Identifier: Apache-2.0
 
-"""Jsonnet library. It is a wrapper on top of filegroup"""
+"""Jsonnet library, a wrapper on top of filegroup, and the rules generated by Gazelle"""
 
 
 def jsonnet_library(name, srcs, visibility, deps = []): native.filegroup(
@@ -10,6 +10,26 @@ def jsonnet_library(name, srcs, visibility, deps = []): native.filegroup(
     visibility = visibility,
 )
 
+def jsonnet_format_test(name, **kwargs):
+    """Test of the formatting of the jsonnet files of a package, generated by Gazelle.
+
+    It is a sh_test running jsonnetfmt, its srcs, with its args.
+    """
+    native.sh_test(
+        name = name,
+        **kwargs
+    )
+
+def jsonnet_outputs(name, **kwargs):
+    """Outputs of the jsonnet_to_json rules of a package, generated by Gazelle.
+
+    It is a filegroup of the rules, and of the outputs of the subpackages.
+    """
+    native.filegroup(
+        name = name,
+        **kwargs
+    )
+
 # Usage:
 
 
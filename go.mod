@@ -4,5 +4,6 @@ go 1.13
 
 require (
 	github.com/bazelbuild/bazel-gazelle v0.19.0
+	github.com/bazelbuild/buildtools v0.0.0-20190731111112-f720930ceb60
 	github.com/google/go-jsonnet v0.15.0
 )
@@ -0,0 +1,118 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	// aggregatesOff generates no aggregate rule
+	aggregatesOff = "off"
+	// aggregatesPackage aggregates the rules of each package
+	aggregatesPackage = "package"
+	// aggregatesRecursive aggregates the rules of each package, and the outputs
+	// of its subpackages
+	aggregatesRecursive = "recursive"
+
+	outputsGroupName  = "jsonnet_outputs"
+	librariesRuleName = "jsonnet_libraries"
+)
+
+// setAggregates sets which aggregate rules are generated from a
+// jsonnet_aggregates value: off, package or recursive.
+func (conf *Config) setAggregates(value string) error {
+	switch value {
+	case aggregatesOff, aggregatesPackage, aggregatesRecursive:
+		conf.Aggregates = value
+		return nil
+	}
+	return fmt.Errorf("unknown value %q: must be %s, %s or %s", value, aggregatesOff, aggregatesPackage, aggregatesRecursive)
+}
+
+// newAggregateRules returns the aggregate rules of a package, given its generated and
+// empty rules: a jsonnet_outputs, the filegroup of def.bzl, of the outputs of its
+// jsonnet_to_json rules, and of the aggregates of its subpackages if recursive, and
+// a jsonnet_library depending on its libraries. Rules written by hand are aggregated
+// too. Aggregates of the build file without content, or no longer generated, are
+// returned as empty rules, so they are deleted.
+//
+// Gazelle visits subpackages first: the packages with a filegroup are recorded for
+// their parent.
+func (l *Lang) newAggregateRules(args language.GenerateArgs, conf *Config, gen, empty []*rule.Rule) (aggGen, aggEmpty []*rule.Rule) {
+	outputs := packageRules(args.File, toJSONRule, gen, empty)
+	libraries := packageRules(args.File, libraryRule, gen, empty)
+	delete(libraries, librariesRuleName)
+
+	var srcs, deps []string
+	if conf.Aggregates != aggregatesOff {
+		for name := range outputs {
+			srcs = append(srcs, ":"+name)
+		}
+		for name := range libraries {
+			deps = append(deps, ":"+name)
+		}
+	}
+	if conf.Aggregates == aggregatesRecursive {
+		for _, sub := range args.Subdirs {
+			if rel := path.Join(args.Rel, sub); l.outputGroups[rel] {
+				srcs = append(srcs, label.New("", rel, outputsGroupName).String())
+			}
+		}
+	}
+
+	if len(srcs) > 0 {
+		sort.Strings(srcs)
+		r := rule.NewRule(outputsRule, outputsGroupName)
+		r.SetAttr("srcs", srcs)
+		r.SetAttr("visibility", []string{"//visibility:public"})
+		aggGen = append(aggGen, r)
+		l.outputGroups[args.Rel] = true
+	} else if hasRule(args.File, outputsRule, outputsGroupName) {
+		aggEmpty = append(aggEmpty, rule.NewRule(outputsRule, outputsGroupName))
+	}
+
+	if len(deps) > 0 {
+		sort.Strings(deps)
+		r := rule.NewRule(libraryRule, librariesRuleName)
+		r.SetAttr("deps", deps)
+		r.SetAttr("visibility", []string{"//visibility:public"})
+		aggGen = append(aggGen, r)
+	} else if hasRule(args.File, libraryRule, librariesRuleName) {
+		aggEmpty = append(aggEmpty, rule.NewRule(libraryRule, librariesRuleName))
+	}
+	return aggGen, aggEmpty
+}
+
+// packageRules returns the names of the rules of a kind a package ends up with: the
+// generated ones, and the ones of its build file that are not deleted.
+func packageRules(f *rule.File, kind string, gen, empty []*rule.Rule) map[string]bool {
+	names := map[string]bool{}
+	for _, r := range gen {
+		if r.Kind() == kind {
+			names[r.Name()] = true
+		}
+	}
+	if f == nil {
+		return names
+	}
+	deleted := map[string]bool{}
+	for _, r := range empty {
+		if r.Kind() == kind {
+			deleted[r.Name()] = true
+		}
+	}
+	for _, r := range f.Rules {
+		if r.Kind() == kind && (!deleted[r.Name()] || r.ShouldKeep()) {
+			names[r.Name()] = true
+		}
+	}
+	return names
+}
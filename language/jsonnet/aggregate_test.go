@@ -0,0 +1,201 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestAggregates(t *testing.T) {
+	testCases := []struct {
+		desc, directives, old, want string
+	}{
+		{
+			desc:       "new",
+			directives: "# gazelle:jsonnet_aggregates package\n",
+			want: `jsonnet_library(
+    name = "jsonnet_libraries",
+    visibility = ["//visibility:public"],
+    deps = [
+        ":lib_library",
+        ":main_library",
+    ],
+)
+
+jsonnet_outputs(
+    name = "jsonnet_outputs",
+    srcs = [
+        ":lib_to_json",
+        ":main_to_json",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			desc:       "stale",
+			directives: "# gazelle:jsonnet_aggregates package\n",
+			old: `jsonnet_to_json(
+    name = "custom_to_json",
+    src = "main.jsonnet",
+    outs = ["custom.json"],
+)
+
+jsonnet_outputs(
+    name = "jsonnet_outputs",
+    srcs = [
+        ":main_to_json",
+        ":old_to_json",
+    ],
+)
+
+jsonnet_library(
+    name = "jsonnet_libraries",
+    deps = [":old_library"],
+)
+`,
+			want: `jsonnet_to_json(
+    name = "custom_to_json",
+    src = "main.jsonnet",
+    outs = ["custom.json"],
+)
+
+jsonnet_outputs(
+    name = "jsonnet_outputs",
+    srcs = [
+        ":custom_to_json",
+        ":lib_to_json",
+        ":main_to_json",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+jsonnet_library(
+    name = "jsonnet_libraries",
+    visibility = ["//visibility:public"],
+    deps = [
+        ":lib_library",
+        ":main_library",
+    ],
+)
+`,
+		}, {
+			desc:       "off",
+			directives: "# gazelle:jsonnet_aggregates off\n",
+			old: `jsonnet_outputs(
+    name = "jsonnet_outputs",
+    srcs = [":main_to_json"],
+)
+
+jsonnet_library(
+    name = "jsonnet_libraries",
+    deps = [":main_library"],
+)
+`,
+		}, {
+			desc:       "hand-written",
+			directives: "# gazelle:jsonnet_aggregates off\n",
+			old: `filegroup(
+    name = "jsonnet_outputs",
+    srcs = [":main_to_json"],
+)
+`,
+			want: `filegroup(
+    name = "jsonnet_outputs",
+    srcs = [":main_to_json"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":  "import 'lib.libsonnet'",
+		"app/lib.libsonnet": "{}",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.directives+tc.old)
+			// Only compare the aggregates and the rules written by hand
+			for _, r := range f.Rules {
+				if strings.HasPrefix(r.Name(), "main_") || strings.HasPrefix(r.Name(), "lib_") {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), tc.directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAggregatesRecursive(t *testing.T) {
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":            "{}",
+		"app/empty/prod/main.jsonnet": "{}",
+		"app/dev/main.jsonnet":        "{}",
+	})
+	defer os.RemoveAll(root)
+
+	lang := jsonnet.NewLanguage()
+	c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+	lang.Configure(c, "", nil)
+	f, err := rule.LoadData(filepath.Join(root, "app", "BUILD.bazel"), "app", []byte("# gazelle:jsonnet_aggregates recursive\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := map[string]*config.Config{"app": c.Clone()}
+	lang.Configure(configs["app"], "app", f)
+	for _, rel := range []string{"app/dev", "app/empty", "app/empty/prod"} {
+		configs[rel] = configs[filepath.Dir(rel)].Clone()
+		lang.Configure(configs[rel], rel, nil)
+	}
+
+	// Subpackages are visited first
+	packages := []struct {
+		rel            string
+		files, subdirs []string
+	}{
+		{rel: "app/dev", files: []string{"main.jsonnet"}},
+		{rel: "app/empty/prod", files: []string{"main.jsonnet"}},
+		{rel: "app/empty", subdirs: []string{"prod"}},
+		{rel: "app", files: []string{"main.jsonnet"}, subdirs: []string{"dev", "empty"}},
+	}
+	var res language.GenerateResult
+	for _, pkg := range packages {
+		args := language.GenerateArgs{
+			Config:       configs[pkg.rel],
+			Dir:          filepath.Join(root, pkg.rel),
+			Rel:          pkg.rel,
+			RegularFiles: pkg.files,
+			Subdirs:      pkg.subdirs,
+		}
+		if pkg.rel == "app" {
+			args.File = f
+		}
+		res = lang.GenerateRules(args)
+	}
+
+	want := []string{"//app/dev:jsonnet_outputs", "//app/empty:jsonnet_outputs", ":main_to_json"}
+	for _, r := range res.Gen {
+		if r.Kind() != "jsonnet_outputs" {
+			continue
+		}
+		if got := r.AttrStrings("srcs"); !reflect.DeepEqual(got, want) {
+			t.Errorf("srcs: got %q; want %q", got, want)
+		}
+		return
+	}
+	t.Errorf("got no jsonnet_outputs")
+}
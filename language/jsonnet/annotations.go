@@ -0,0 +1,127 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	annotationPrefix = "gazelle:"
+
+	// skipToJSONAnnotation generates no jsonnet_to_json rule for the file
+	skipToJSONAnnotation = "skip_to_json"
+	// outputAnnotation sets the output of the jsonnet_to_json rule of the file
+	outputAnnotation = "output"
+	// extVarAnnotation sets an external variable for the file, as jsonnet_ext_var
+	extVarAnnotation = "ext_var"
+	// multipleOutputsAnnotation sets the outputs of the file evaluated with
+	// jsonnet -m, as jsonnet_multiple_outputs
+	multipleOutputsAnnotation = "multiple_outputs"
+	// ignoreImportAnnotation ignores an import of the file, as written
+	ignoreImportAnnotation = "ignore_import"
+)
+
+var (
+	knownAnnotations = []string{
+		skipToJSONAnnotation,
+		outputAnnotation,
+		extVarAnnotation,
+		multipleOutputsAnnotation,
+		ignoreImportAnnotation,
+	}
+)
+
+// parseAnnotations returns the "// gazelle:key value" comments at the top of a
+// snippet, before its first expression. Other comments are skipped.
+func parseAnnotations(filename, snippet string) []fileinfo.Annotation {
+	var annotations []fileinfo.Annotation
+	inBlock := false
+	for i, line := range strings.Split(snippet, "\n") {
+		text := strings.TrimSpace(line)
+		switch {
+		case inBlock, strings.HasPrefix(text, "/*"):
+			if !inBlock {
+				text = text[2:]
+			}
+			end := strings.Index(text, "*/")
+			inBlock = end < 0
+			// Code after the end of the comment is the first expression
+			if !inBlock && !isLineComment(strings.TrimSpace(text[end+2:])) {
+				return annotations
+			}
+		case text == "", strings.HasPrefix(text, "#"):
+		case strings.HasPrefix(text, "//"):
+			comment := strings.TrimSpace(text[2:])
+			if !strings.HasPrefix(comment, annotationPrefix) {
+				continue
+			}
+			key, value := splitField(strings.TrimPrefix(comment, annotationPrefix))
+			annotations = append(annotations, fileinfo.Annotation{
+				Key:   key,
+				Value: value,
+				Pos:   fileinfo.Position{Filename: filename, Line: i + 1, Column: strings.Index(line, "//") + 1},
+			})
+		default:
+			return annotations
+		}
+	}
+	return annotations
+}
+
+// isLineComment returns whether the rest of a line is empty or a comment
+func isLineComment(text string) bool {
+	return text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "//")
+}
+
+// forFile returns the configuration of a file, with the settings of its annotations.
+// Unknown annotations and invalid values are reported.
+func (conf *Config) forFile(finfo fileinfo.FileInfo) *Config {
+	if len(finfo.Annotations) == 0 {
+		return conf
+	}
+	cc := conf.clone()
+	for _, a := range finfo.Annotations {
+		var err error
+		switch a.Key {
+		case skipToJSONAnnotation:
+			skip := true
+			if a.Value != "" {
+				skip, err = strconv.ParseBool(a.Value)
+			}
+			cc.SkipToJSON[finfo.Path.Path] = skip
+		case outputAnnotation:
+			err = cc.setOutputName(finfo.Path, a.Value)
+		case extVarAnnotation:
+			err = cc.addExtVar(a.Value)
+		case multipleOutputsAnnotation:
+			err = cc.addMultipleOutputs(finfo.Path.Package, finfo.Path.Filename+" "+a.Value)
+		case ignoreImportAnnotation:
+			// Ignored imports are left out when the file is parsed
+		default:
+			log.Printf("%s: unknown annotation %q: must be one of %s", a.Pos, a.Key, strings.Join(knownAnnotations, ", "))
+		}
+		if err != nil {
+			log.Printf("%s: %s annotation: %v", a.Pos, a.Key, err)
+		}
+	}
+	return cc
+}
+
+// setOutputName sets the output of the jsonnet_to_json rule of a file, e.g.
+// "deployment.yaml". The outputs of variants are named after them, e.g.
+// deployment.prod.yaml.
+func (conf *Config) setOutputName(fpath fileinfo.FilePath, value string) error {
+	if value == "" || strings.ContainsAny(value, "/: ") || path.Ext(value) == "" {
+		return fmt.Errorf("got %q: want a filename with an extension", value)
+	}
+	conf.OutputNames[fpath.Path] = value
+	return nil
+}
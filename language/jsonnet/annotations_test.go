@@ -0,0 +1,107 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	old := `jsonnet_to_json(
+    name = "skip_to_json",
+    src = "skip.jsonnet",
+    outs = ["skip.json"],
+)
+`
+	want := `jsonnet_to_json(
+    name = "deploy_to_json",
+    src = "deploy.jsonnet",
+    outs = ["deployment.yaml"],
+    ext_strs = {
+        "env": "prod",
+    },
+    visibility = ["//visibility:public"],
+    yaml_stream = True,
+    deps = ["//app:deploy_library"],
+)
+
+jsonnet_to_json(
+    name = "multi_to_json",
+    src = "multi.jsonnet",
+    outs = [
+        "a.json",
+        "b.json",
+    ],
+    multiple_outputs = True,
+    visibility = ["//visibility:public"],
+    deps = ["//app:multi_library"],
+)
+
+jsonnet_to_json(
+    name = "unknown_to_json",
+    src = "unknown.jsonnet",
+    outs = ["unknown.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:unknown_library"],
+)
+`
+
+	root := writeWorkspace(t, map[string]string{
+		"app/skip.jsonnet": "// gazelle:skip_to_json\n{}",
+		"app/deploy.jsonnet": `// gazelle:output deployment.yaml
+// gazelle:ext_var env str prod
+[{ apiVersion: 'v1', kind: 'Service', env: std.extVar('env') }]`,
+		"app/multi.jsonnet":   "// gazelle:multiple_outputs a.json b.json\n{ [x + '.json']: {} for x in ['a', 'b'] }",
+		"app/unknown.jsonnet": "// gazelle:typo value\n{}",
+	})
+	defer os.RemoveAll(root)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	f := update(t, root, "app", old)
+	for _, r := range f.Rules {
+		if r.Kind() != "jsonnet_to_json" {
+			r.Delete()
+		}
+	}
+	if got := string(f.Format()); strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if want := `app/unknown.jsonnet:1:1: unknown annotation "typo"`; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs: got %q; want %q", logs.String(), want)
+	}
+}
+
+func TestAnnotationsAfterBlockComment(t *testing.T) {
+	want := `jsonnet_to_json(
+    name = "license_to_json",
+    src = "license.jsonnet",
+    outs = ["license.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:license_library"],
+)
+`
+
+	root := writeWorkspace(t, map[string]string{
+		// The annotation follows the first expression, so it is not one
+		"app/license.jsonnet": "/* license */ local x = 1;\n// gazelle:skip_to_json\n{ x: x }",
+		"app/header.jsonnet":  "/*\n * license\n */ // header\n// gazelle:skip_to_json\n{}",
+	})
+	defer os.RemoveAll(root)
+
+	f := update(t, root, "app", "")
+	for _, r := range f.Rules {
+		if r.Kind() != "jsonnet_to_json" {
+			r.Delete()
+		}
+	}
+	if got := string(f.Format()); strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bundler provides support for workspaces whose third-party jsonnet
+// is managed by jsonnet-bundler, i.e. vendored following a jsonnetfile.
+package bundler
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Bundle is a directory managed by jsonnet-bundler
+type Bundle struct {
+	Dir     string   // Workspace-relative directory containing the jsonnetfile
+	Vendor  string   // Workspace-relative directory containing the vendored modules
+	Modules []Module // Vendored modules
+}
+
+// Module is a vendored dependency
+type Module struct {
+	Name       string // Legacy name, e.g. grafonnet
+	ImportPath string // Import path, e.g. github.com/grafana/grafonnet-lib/grafonnet
+	Dir        string // Workspace-relative directory containing the vendored files
+}
+
+// Load returns the Bundle of a workspace-relative directory, or nil if it
+// contains no jsonnetfile. The lock file is preferred over the jsonnetfile,
+// as it lists the transitive dependencies too.
+func Load(root, rel string) (*Bundle, error) {
+	var f *File
+	for _, name := range []string{LockFile, JsonnetFile} {
+		var err error
+		f, err = ReadFile(filepath.Join(root, rel, name))
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if f == nil {
+		return nil, nil
+	}
+
+	b := &Bundle{Dir: rel, Vendor: path.Join(rel, VendorDir)}
+	for _, dep := range f.Dependencies {
+		m := Module{Name: dep.LegacyName(), ImportPath: dep.ImportPath()}
+		if m.ImportPath == "" {
+			continue
+		}
+		// Modules are vendored at their import path and linked at their legacy
+		// name, but older versions of jsonnet-bundler only vendor them at their
+		// legacy name. Prefer the actual directory over the link.
+		m.Dir = path.Join(b.Vendor, m.ImportPath)
+		if fi, err := os.Lstat(filepath.Join(root, m.Dir)); err != nil || !fi.IsDir() {
+			if fi, err := os.Lstat(filepath.Join(root, b.Vendor, m.Name)); err == nil && fi.IsDir() {
+				m.Dir = path.Join(b.Vendor, m.Name)
+			}
+		}
+		b.Modules = append(b.Modules, m)
+	}
+	return b, nil
+}
+
+// IsVendored returns whether a workspace-relative path belongs to the vendor directory
+func (b *Bundle) IsVendored(rel string) bool {
+	return b != nil && isWithin(rel, b.Vendor)
+}
+
+// Module returns the module a workspace-relative path belongs to, if any
+func (b *Bundle) Module(rel string) *Module {
+	if b == nil {
+		return nil
+	}
+	var found *Module
+	for i, m := range b.Modules {
+		if isWithin(rel, m.Dir) && (found == nil || len(m.Dir) > len(found.Dir)) {
+			found = &b.Modules[i]
+		}
+	}
+	return found
+}
+
+// ResolveImport returns the workspace-relative path of an import found in the
+// vendor directory, i.e. starting with the import path or legacy name of a module.
+func (b *Bundle) ResolveImport(importstr string) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+	importstr = path.Clean(importstr)
+	var found *Module
+	var prefix string
+	for i, m := range b.Modules {
+		for _, p := range []string{m.ImportPath, m.Name} {
+			if isWithin(importstr, p) && len(p) > len(prefix) {
+				found, prefix = &b.Modules[i], p
+			}
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return path.Join(found.Dir, strings.TrimPrefix(importstr, prefix)), true
+}
+
+// isWithin returns whether path is dir or a path in dir
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundler_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
+)
+
+const lockFile = `{
+  "version": 1,
+  "dependencies": [
+    {
+      "source": {"git": {"remote": "https://github.com/grafana/grafonnet-lib.git", "subdir": "grafonnet"}},
+      "version": "3626fc4dee2ca8ca6bd2b8b9b9c48b4e3e0fc4b0",
+      "sum": "gF8foHByYcB25jcUOBqP6jxk0OPifQMjPvKY0HaCk6w="
+    },
+    {
+      "source": {"git": {"remote": "https://github.com/bitnami-labs/kube-libsonnet.git", "subdir": ""}},
+      "version": "96b30825c33b7286894c095be19b7b90687b1ede"
+    }
+  ],
+  "legacyImports": true
+}`
+
+func TestLoad(t *testing.T) {
+	root, err := ioutil.TempDir("", "bundler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{
+		// Current layout
+		"jsonnet/vendor/github.com/grafana/grafonnet-lib/grafonnet",
+		// Older layout, without import path
+		"jsonnet/vendor/kube-libsonnet",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "jsonnet", bundler.LockFile), []byte(lockFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := bundler.Load(root, ""); err != nil || b != nil {
+		t.Errorf("no jsonnetfile: got: %v, %v; want: nil, nil", b, err)
+	}
+
+	b, err := bundler.Load(root, "jsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &bundler.Bundle{
+		Dir:    "jsonnet",
+		Vendor: "jsonnet/vendor",
+		Modules: []bundler.Module{
+			{Name: "grafonnet", ImportPath: "github.com/grafana/grafonnet-lib/grafonnet", Dir: "jsonnet/vendor/github.com/grafana/grafonnet-lib/grafonnet"},
+			{Name: "kube-libsonnet", ImportPath: "github.com/bitnami-labs/kube-libsonnet", Dir: "jsonnet/vendor/kube-libsonnet"},
+		},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("got: %+v; want: %+v", b, want)
+	}
+}
+
+func TestResolveImport(t *testing.T) {
+	b := &bundler.Bundle{
+		Dir:    "",
+		Vendor: "vendor",
+		Modules: []bundler.Module{
+			{Name: "grafonnet", ImportPath: "github.com/grafana/grafonnet-lib/grafonnet", Dir: "vendor/github.com/grafana/grafonnet-lib/grafonnet"},
+			{Name: "kube", ImportPath: "github.com/bitnami-labs/kube-libsonnet", Dir: "vendor/github.com/bitnami-labs/kube-libsonnet"},
+		},
+	}
+
+	testCases := []struct {
+		importstr, want string
+		found           bool
+		module          string
+	}{
+		{"github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet", "vendor/github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet", true, "grafonnet"},
+		{"grafonnet/grafana.libsonnet", "vendor/github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet", true, "grafonnet"},
+		{"kube/kube.libsonnet", "vendor/github.com/bitnami-labs/kube-libsonnet/kube.libsonnet", true, "kube"},
+		{"grafonnet-lib/grafana.libsonnet", "", false, ""},
+		{"lib/utils.libsonnet", "", false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.importstr, func(t *testing.T) {
+			got, found := b.ResolveImport(tc.importstr)
+			if got != tc.want || found != tc.found {
+				t.Errorf("got: %q, %t; want: %q, %t", got, found, tc.want, tc.found)
+			}
+			if !found {
+				return
+			}
+			if m := b.Module(got); m == nil || m.Name != tc.module {
+				t.Errorf("module of %q: got: %v; want: %q", got, m, tc.module)
+			}
+		})
+	}
+
+	if !b.IsVendored("vendor/github.com") || b.IsVendored("vendors") {
+		t.Error("IsVendored: wrong vendor directory")
+	}
+	var none *bundler.Bundle
+	if _, found := none.ResolveImport("grafonnet/grafana.libsonnet"); found {
+		t.Error("nil bundle: resolved import")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// File names used by jsonnet-bundler
+const (
+	JsonnetFile = "jsonnetfile.json"
+	LockFile    = "jsonnetfile.lock.json"
+	VendorDir   = "vendor"
+)
+
+// File is the content of a jsonnetfile.json or jsonnetfile.lock.json
+type File struct {
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Dependency is a dependency of a jsonnetfile
+type Dependency struct {
+	Source  Source `json:"source"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+	Name    string `json:"name,omitempty"` // Legacy name, if different from the default one
+}
+
+// Source is the location a dependency is fetched from
+type Source struct {
+	Git   *GitSource   `json:"git,omitempty"`
+	Local *LocalSource `json:"local,omitempty"`
+}
+
+// GitSource is a directory of a git repository
+type GitSource struct {
+	Remote string `json:"remote"`
+	Subdir string `json:"subdir"`
+}
+
+// LocalSource is a directory of the local filesystem
+type LocalSource struct {
+	Directory string `json:"directory"`
+}
+
+// ReadFile reads a jsonnetfile.json or jsonnetfile.lock.json
+func ReadFile(filename string) (*File, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ImportPath returns the path jsonnet-bundler vendors the dependency at,
+// e.g. github.com/grafana/grafonnet-lib/grafonnet.
+func (d Dependency) ImportPath() string {
+	switch {
+	case d.Source.Git != nil:
+		return path.Join(d.Source.Git.host(), d.Source.Git.Subdir)
+	case d.Source.Local != nil:
+		return d.LegacyName()
+	}
+	return ""
+}
+
+// LegacyName returns the name jsonnet-bundler links the dependency with,
+// so it can be imported without the host, e.g. grafonnet.
+func (d Dependency) LegacyName() string {
+	switch {
+	case d.Name != "":
+		return d.Name
+	case d.Source.Git != nil && d.Source.Git.Subdir != "":
+		return path.Base(d.Source.Git.Subdir)
+	case d.Source.Git != nil:
+		return path.Base(d.Source.Git.host())
+	case d.Source.Local != nil:
+		return path.Base(d.Source.Local.Directory)
+	}
+	return ""
+}
+
+// host returns the remote without scheme, user and .git suffix,
+// e.g. github.com/grafana/grafonnet-lib
+func (g GitSource) host() string {
+	remote := strings.TrimSuffix(g.Remote, ".git")
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		return path.Join(u.Host, u.Path)
+	}
+	// scp-like syntax, e.g. git@github.com:grafana/grafonnet-lib
+	if i := strings.Index(remote, "@"); i >= 0 {
+		remote = remote[i+1:]
+	}
+	return strings.Replace(remote, ":", "/", 1)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundler_test
+
+import (
+	"testing"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
+)
+
+func TestDependencyNames(t *testing.T) {
+	testCases := []struct {
+		desc                   string
+		dep                    bundler.Dependency
+		importPath, legacyName string
+	}{
+		{
+			desc:       "git subdir",
+			dep:        bundler.Dependency{Source: bundler.Source{Git: &bundler.GitSource{Remote: "https://github.com/grafana/grafonnet-lib.git", Subdir: "grafonnet"}}},
+			importPath: "github.com/grafana/grafonnet-lib/grafonnet",
+			legacyName: "grafonnet",
+		}, {
+			desc:       "git root",
+			dep:        bundler.Dependency{Source: bundler.Source{Git: &bundler.GitSource{Remote: "https://github.com/bitnami-labs/kube-libsonnet"}}},
+			importPath: "github.com/bitnami-labs/kube-libsonnet",
+			legacyName: "kube-libsonnet",
+		}, {
+			desc:       "git scp-like remote",
+			dep:        bundler.Dependency{Source: bundler.Source{Git: &bundler.GitSource{Remote: "git@github.com:kubernetes-monitoring/kubernetes-mixin.git", Subdir: "lib/promgrafonnet"}}},
+			importPath: "github.com/kubernetes-monitoring/kubernetes-mixin/lib/promgrafonnet",
+			legacyName: "promgrafonnet",
+		}, {
+			desc:       "named",
+			dep:        bundler.Dependency{Name: "mixin", Source: bundler.Source{Git: &bundler.GitSource{Remote: "https://github.com/etcd-io/etcd", Subdir: "Documentation/etcd-mixin"}}},
+			importPath: "github.com/etcd-io/etcd/Documentation/etcd-mixin",
+			legacyName: "mixin",
+		}, {
+			desc:       "local",
+			dep:        bundler.Dependency{Source: bundler.Source{Local: &bundler.LocalSource{Directory: "../shared/lib"}}},
+			importPath: "lib",
+			legacyName: "lib",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.dep.ImportPath(); got != tc.importPath {
+				t.Errorf("import path: got: %q; want: %q", got, tc.importPath)
+			}
+			if got := tc.dep.LegacyName(); got != tc.legacyName {
+				t.Errorf("legacy name: got: %q; want: %q", got, tc.legacyName)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	// moduleFile declares the dependencies of a workspace using Bzlmod
+	moduleFile = "MODULE.bazel"
+	// rulesModule is the name of the rules_jsonnet module in the Bazel Central Registry
+	rulesModule = "rules_jsonnet"
+	// defaultRulesRepo is the name rules_jsonnet is given in WORKSPACE files
+	defaultRulesRepo = "io_bazel_rules_jsonnet"
+	// repoRootFlag is the flag of Gazelle setting the root of the workspace
+	repoRootFlag = "repo_root"
+	// defsLoad is the def.bzl file of this repository, see the README
+	defsLoad = "@jsonnet_gazelle//:def.bzl"
+)
+
+// rulesLoad returns the label of the file defining the jsonnet rules in a repository
+func rulesLoad(repo string) string {
+	return "@" + repo + "//jsonnet:jsonnet.bzl"
+}
+
+// newLoads returns the loads of the rules of the language, loading the jsonnet
+// rules from a repository.
+func newLoads(repo string) []rule.LoadInfo {
+	return []rule.LoadInfo{
+		{
+			Name: rulesLoad(repo),
+			Symbols: []string{
+				libraryRule,
+				toJSONRule,
+				toJSONTestRule,
+			},
+		},
+		{
+			Name:    defsLoad,
+			Symbols: []string{formatTestRule, outputsRule},
+		},
+		{
+			Name:    "@bazel_tools//tools/build_defs/repo:git.bzl",
+			Symbols: []string{gitRepositoryRule},
+		},
+	}
+}
+
+// moduleRulesRepo returns the apparent name of rules_jsonnet in the MODULE.bazel
+// file of a workspace, if it depends on it: its repo_name, or the name of the module.
+func moduleRulesRepo(root string) (string, bool) {
+	path := filepath.Join(root, moduleFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	f, err := rule.LoadData(path, "", data)
+	if err != nil {
+		return "", false
+	}
+	for _, r := range f.Rules {
+		if r.Kind() != "bazel_dep" || r.AttrString("name") != rulesModule {
+			continue
+		}
+		if name := r.AttrString("repo_name"); name != "" {
+			return name, true
+		}
+		return rulesModule, true
+	}
+	return "", false
+}
+
+// rulesRepo returns the repository providing the jsonnet rules to a workspace:
+// the one set by the jsonnet_rules_repo directive of its root build file, if any,
+// or the apparent name of rules_jsonnet in its MODULE.bazel file, if it depends on it,
+// or io_bazel_rules_jsonnet.
+func rulesRepo(root string, f *rule.File) string {
+	if f != nil {
+		for _, d := range f.Directives {
+			if d.Key == rulesRepoDirective && d.Value != "" {
+				return strings.TrimPrefix(d.Value, "@")
+			}
+		}
+	}
+	if name, found := moduleRulesRepo(root); found {
+		return name
+	}
+	return defaultRulesRepo
+}
+
+// findRulesRepo returns the repository providing the jsonnet rules to the workspace
+// Gazelle runs on, given its command line arguments, see findRepoRoot. It is used by
+// Loads, which is called before the flags are parsed: CheckFlags fails if the
+// workspace turns out to be another one.
+func findRulesRepo(args []string) string {
+	root, found := findRepoRoot(args)
+	if !found {
+		return defaultRulesRepo
+	}
+	return rulesRepo(root, loadRootFile(root))
+}
+
+// findRepoRoot returns the root of the workspace Gazelle runs on, found the way
+// Gazelle finds it: the value of its -repo_root flag, if set, or the workspace
+// containing the directory bazel run was called from, BUILD_WORKSPACE_DIRECTORY,
+// or the working directory.
+func findRepoRoot(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case name == repoRootFlag && i+1 < len(args):
+			return args[i+1], true
+		case strings.HasPrefix(name, repoRootFlag+"="):
+			return strings.TrimPrefix(name, repoRootFlag+"="), true
+		}
+	}
+
+	dir := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", false
+		}
+	}
+	for {
+		for _, name := range fileinfo.RepoBoundaryFiles {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// loadRootFile loads the build file of the root of a workspace, if any
+func loadRootFile(root string) *rule.File {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		if f, err := rule.LoadFile(filepath.Join(root, name), ""); err == nil {
+			return f
+		}
+	}
+	return nil
+}
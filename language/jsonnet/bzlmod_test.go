@@ -0,0 +1,172 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestRulesRepo(t *testing.T) {
+	testCases := []struct {
+		desc, module, build, want string
+	}{
+		{
+			desc: "workspace",
+			want: "io_bazel_rules_jsonnet",
+		}, {
+			desc:   "module without rules_jsonnet",
+			module: `bazel_dep(name = "rules_go", version = "0.41.0")`,
+			want:   "io_bazel_rules_jsonnet",
+		}, {
+			desc:   "module",
+			module: `bazel_dep(name = "rules_jsonnet", version = "0.5.0")`,
+			want:   "rules_jsonnet",
+		}, {
+			desc: "module with repo_name",
+			module: `module(name = "app")
+
+bazel_dep(name = "rules_jsonnet", version = "0.5.0", repo_name = "io_bazel_rules_jsonnet")`,
+			want: "io_bazel_rules_jsonnet",
+		}, {
+			desc:   "directive",
+			module: `bazel_dep(name = "rules_jsonnet", version = "0.5.0", repo_name = "jsonnet_rules")`,
+			build:  "# gazelle:jsonnet_rules_repo @my_rules_jsonnet",
+			want:   "my_rules_jsonnet",
+		},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			files := map[string]string{"WORKSPACE": "", "BUILD.bazel": tc.build}
+			if tc.module != "" {
+				files["MODULE.bazel"] = tc.module
+			}
+			for name, content := range files {
+				if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.MkdirAll(filepath.Join(root, "app"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			// Loads are found from the working directory
+			if err := os.Chdir(filepath.Join(root, "app")); err != nil {
+				t.Fatal(err)
+			}
+			lang := jsonnet.NewLanguage()
+			if got, want := lang.Loads()[0].Name, "@"+tc.want+"//jsonnet:jsonnet.bzl"; got != want {
+				t.Errorf("got load %q; want %q", got, want)
+			}
+
+			f, err := rule.LoadFile(filepath.Join(root, "BUILD.bazel"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+			lang.Configure(c, "", f)
+			if got := jsonnet.GetConfig(c).RulesRepo; got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRulesRepoRoot(t *testing.T) {
+	module := writeWorkspace(t, map[string]string{
+		"WORKSPACE":    "",
+		"MODULE.bazel": `bazel_dep(name = "rules_jsonnet", version = "0.5.0")`,
+	})
+	defer os.RemoveAll(module)
+	other := writeWorkspace(t, map[string]string{"WORKSPACE": ""})
+	defer os.RemoveAll(other)
+
+	testCases := []struct {
+		desc, env, root, want string
+		args                  []string
+		fail                  bool
+	}{
+		{
+			desc: "flag",
+			args: []string{"update", "-repo_root", module},
+			root: module,
+			want: "rules_jsonnet",
+		}, {
+			desc: "flag with value",
+			args: []string{"update", "--repo_root=" + module},
+			root: module,
+			want: "rules_jsonnet",
+		}, {
+			desc: "bazel run",
+			env:  module,
+			root: module,
+			want: "rules_jsonnet",
+		}, {
+			desc: "other workspace",
+			root: module,
+			want: "io_bazel_rules_jsonnet",
+			fail: true,
+		},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(other); err != nil {
+		t.Fatal(err)
+	}
+	args := os.Args
+	defer func() { os.Args = args }()
+	env, found := os.LookupEnv("BUILD_WORKSPACE_DIRECTORY")
+	defer func() {
+		if found {
+			os.Setenv("BUILD_WORKSPACE_DIRECTORY", env)
+		} else {
+			os.Unsetenv("BUILD_WORKSPACE_DIRECTORY")
+		}
+	}()
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			os.Args = append([]string{"gazelle"}, tc.args...)
+			if tc.env != "" {
+				os.Setenv("BUILD_WORKSPACE_DIRECTORY", tc.env)
+			} else {
+				os.Unsetenv("BUILD_WORKSPACE_DIRECTORY")
+			}
+
+			lang := jsonnet.NewLanguage()
+			if got, want := lang.Loads()[0].Name, "@"+tc.want+"//jsonnet:jsonnet.bzl"; got != want {
+				t.Errorf("got load %q; want %q", got, want)
+			}
+			// The loads cannot be written for another workspace
+			c := &config.Config{RepoRoot: tc.root, Exts: map[string]interface{}{}}
+			if err := lang.CheckFlags(flag.NewFlagSet("gazelle", flag.ContinueOnError), c); (err != nil) != tc.fail {
+				t.Errorf("got error %v; want failure %v", err, tc.fail)
+			}
+		})
+	}
+}
@@ -4,28 +4,145 @@
 package jsonnet
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
 )
 
 // Config states the jsonnet configuration
 type Config struct {
-	NativeImports map[string]bool
-	IgnoreFolders map[string]bool
+	NativeImports  map[string]bool
+	IgnoreFolders  map[string]bool
+	MissingImports string
+	MergeCycles    bool
+	GraphOut       string
+	GraphFormat    string
+	// Bundle is the closest directory managed by jsonnet-bundler, if any
+	Bundle        *bundler.Bundle
+	ExternalRepos []ExternalRepo
+	ImportAliases []ImportAlias
+	// RulesRepo is the repository providing the jsonnet rules, e.g. rules_jsonnet
+	RulesRepo string
+	// LocalRepos maps the workspace directories of nested repositories to their names
+	LocalRepos map[string]string
+	// ExtVars maps the names of external variables to their values
+	ExtVars map[string]VarValue
+	// TLAs maps the names of top-level arguments to their values
+	TLAs map[string]VarValue
+	// ForcedOutputs maps the workspace paths of files to the files they write when
+	// evaluated with jsonnet -m. Files without output are evaluated as usual.
+	ForcedOutputs map[string][]string
+	// OutputFormat is the format of the outputs of jsonnet_to_json rules
+	OutputFormat string
+	// SkipToJSON contains the workspace paths of the files without jsonnet_to_json
+	// rule, from their annotations
+	SkipToJSON map[string]bool
+	// OutputNames maps the workspace paths of files to the output of their
+	// jsonnet_to_json rule, from their annotations
+	OutputNames map[string]string
+	// StampVars are the external variables given the values of the workspace status
+	// of stamped builds, e.g. BUILD_SCM_REVISION
+	StampVars map[string]bool
+	// Variants are the values of an external variable jsonnet_to_json rules are
+	// generated for, if any
+	Variants *Variants
+	// GoldenSuffixes are the suffixes of the golden files jsonnet_to_json_test
+	// rules are generated for, e.g. .golden.json for main.golden.json
+	GoldenSuffixes []string
+	// ErrorSuffixes are the suffixes of the expected errors of the files named
+	// *_error, e.g. .txt for main_error.txt
+	ErrorSuffixes []string
+	// FormatTest generates a test checking the formatting of the jsonnet files of
+	// each package
+	FormatTest bool
+	// Formatter is the label of the jsonnetfmt binary run by the format tests
+	Formatter string
+	// FormatOptions maps the options of jsonnetfmt to their values
+	FormatOptions map[string]string
+	// Aggregates states which aggregate rules are generated: off, package or recursive
+	Aggregates string
 }
 
 func newConfig() *Config {
 	conf := &Config{
-		NativeImports: make(map[string]bool, len(nativeImports)),
-		IgnoreFolders: make(map[string]bool),
+		NativeImports:  make(map[string]bool, len(nativeImports)),
+		IgnoreFolders:  make(map[string]bool),
+		MissingImports: missingImportsKeep,
+		GraphFormat:    graphFormatJSON,
+		LocalRepos:     make(map[string]string),
+		ExtVars:        make(map[string]VarValue),
+		TLAs:           make(map[string]VarValue),
+		ForcedOutputs:  make(map[string][]string),
+		OutputFormat:   outputFormatAuto,
+		SkipToJSON:     make(map[string]bool),
+		OutputNames:    make(map[string]string),
+		StampVars:      make(map[string]bool),
+		GoldenSuffixes: goldenSuffixes,
+		ErrorSuffixes:  errorSuffixes,
+		Formatter:      defaultFormatter,
+		FormatOptions:  make(map[string]string),
+		Aggregates:     aggregatesOff,
 	}
 	conf.setNativeImports(strings.Join(nativeImports, ","))
 	return conf
 }
 
+// clone returns a copy of the Config, so directives only apply to
+// the directory where they are set and its subdirectories.
+func (conf *Config) clone() *Config {
+	cc := *conf
+	cc.NativeImports = make(map[string]bool, len(conf.NativeImports))
+	for k, v := range conf.NativeImports {
+		cc.NativeImports[k] = v
+	}
+	cc.IgnoreFolders = make(map[string]bool, len(conf.IgnoreFolders))
+	for k, v := range conf.IgnoreFolders {
+		cc.IgnoreFolders[k] = v
+	}
+	cc.ExternalRepos = append([]ExternalRepo(nil), conf.ExternalRepos...)
+	cc.ImportAliases = append([]ImportAlias(nil), conf.ImportAliases...)
+	cc.LocalRepos = make(map[string]string, len(conf.LocalRepos))
+	for k, v := range conf.LocalRepos {
+		cc.LocalRepos[k] = v
+	}
+	cc.ExtVars = make(map[string]VarValue, len(conf.ExtVars))
+	for k, v := range conf.ExtVars {
+		cc.ExtVars[k] = v
+	}
+	cc.TLAs = make(map[string]VarValue, len(conf.TLAs))
+	for k, v := range conf.TLAs {
+		cc.TLAs[k] = v
+	}
+	cc.StampVars = make(map[string]bool, len(conf.StampVars))
+	for k, v := range conf.StampVars {
+		cc.StampVars[k] = v
+	}
+	cc.SkipToJSON = make(map[string]bool, len(conf.SkipToJSON))
+	for k, v := range conf.SkipToJSON {
+		cc.SkipToJSON[k] = v
+	}
+	cc.OutputNames = make(map[string]string, len(conf.OutputNames))
+	for k, v := range conf.OutputNames {
+		cc.OutputNames[k] = v
+	}
+	cc.FormatOptions = make(map[string]string, len(conf.FormatOptions))
+	for k, v := range conf.FormatOptions {
+		cc.FormatOptions[k] = v
+	}
+	cc.ForcedOutputs = make(map[string][]string, len(conf.ForcedOutputs))
+	for k, v := range conf.ForcedOutputs {
+		cc.ForcedOutputs[k] = v
+	}
+	return &cc
+}
+
 // GetConfig returns a new Config within jsonnet-specs
 func GetConfig(c *config.Config) *Config {
 	conf := c.Exts[languageName]
@@ -36,22 +153,115 @@ func GetConfig(c *config.Config) *Config {
 	return conf.(*Config)
 }
 
-func (*Lang) CheckFlags(fs *flag.FlagSet, c *config.Config) error { return nil }
-func (*Lang) Configure(c *config.Config, rel string, f *rule.File) {
+func (l *Lang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 	conf := GetConfig(c)
+	switch conf.GraphFormat {
+	case graphFormatJSON, graphFormatDOT:
+	default:
+		return fmt.Errorf("-%s: unknown format %q: must be %q or %q", graphFormatFlag, conf.GraphFormat, graphFormatJSON, graphFormatDOT)
+	}
+	// The loads were computed before the flags were parsed, see Loads
+	if repo := rulesRepo(c.RepoRoot, loadRootFile(c.RepoRoot)); l.rulesRepo != "" && l.rulesRepo != repo {
+		return fmt.Errorf("jsonnet rules would be loaded from @%s instead of @%s of %s: run gazelle from the root of the workspace, or with -%s", l.rulesRepo, repo, c.RepoRoot, repoRootFlag)
+	}
+	return nil
+}
+func (l *Lang) Configure(c *config.Config, rel string, f *rule.File) {
+	conf := GetConfig(c).clone()
+	c.Exts[languageName] = conf
+
+	if rel == "" {
+		conf.addLocalRepos(c.RepoRoot, c.Repos)
+		conf.RulesRepo = rulesRepo(c.RepoRoot, f)
+	}
+
+	// Vendored modules may contain their own jsonnetfile, which is not the
+	// one in use.
+	if !conf.Bundle.IsVendored(rel) {
+		if b, err := bundler.Load(c.RepoRoot, rel); err != nil {
+			log.Printf("%s: %v", rel, err)
+		} else if b != nil {
+			conf.Bundle = b
+		}
+	}
 
 	if f != nil {
 		for _, d := range f.Directives {
+			var err error
 			switch d.Key {
 			case ignoreFoldersDirective:
-				conf.setIgnoreFolders(d.Value)
+				err = conf.setIgnoreFolders(d.Value)
+			case missingImportsDirective:
+				err = conf.setMissingImports(d.Value)
+			case mergeCyclesDirective:
+				conf.MergeCycles, err = strconv.ParseBool(d.Value)
+			case externalRepoDirective:
+				err = conf.addExternalRepo(c.Repos, d.Value)
+			case importAliasDirective:
+				err = conf.addImportAlias(d.Value)
+			case localRepoDirective:
+				err = conf.addLocalRepo(d.Value)
+			case extVarDirective:
+				err = conf.addExtVar(d.Value)
+			case tlaDirective:
+				err = conf.addTLA(d.Value)
+			case multipleOutputsDirective:
+				err = conf.addMultipleOutputs(rel, d.Value)
+			case outputFormatDirective:
+				err = conf.setOutputFormat(d.Value)
+			case stampVarDirective:
+				err = conf.addStampVar(d.Value)
+			case variantsDirective:
+				err = conf.setVariants(d.Value)
+			case goldenTestsDirective:
+				err = conf.setGoldenSuffixes(d.Value)
+			case errorTestsDirective:
+				err = conf.setErrorSuffixes(d.Value)
+			case formatTestDirective:
+				conf.FormatTest, err = strconv.ParseBool(d.Value)
+			case formatterDirective:
+				err = conf.setFormatter(d.Value)
+			case formatOptionsDirective:
+				err = conf.addFormatOptions(d.Value)
+			case aggregatesDirective:
+				err = conf.setAggregates(d.Value)
+			case rulesRepoDirective:
+				// Loads are the same for every build file
+				if rel != "" {
+					err = errors.New("only allowed in the root build file")
+				}
+			}
+			if err != nil {
+				log.Printf("%s: %s directive: %v", f.Path, d.Key, err)
 			}
 		}
 	}
+
+	// Files imported from other directories are parsed with the configuration
+	// of their own, see importedFile.
+	l.configs[rel] = c.Clone()
 }
 func (*Lang) KnownDirectives() []string {
 	return []string{
 		ignoreFoldersDirective,
+		missingImportsDirective,
+		mergeCyclesDirective,
+		externalRepoDirective,
+		importAliasDirective,
+		localRepoDirective,
+		rulesRepoDirective,
+		extVarDirective,
+		tlaDirective,
+		multipleOutputsDirective,
+		outputFormatDirective,
+		variantsDirective,
+		stampVarDirective,
+		goldenTestsDirective,
+		errorTestsDirective,
+		formatTestDirective,
+		formatterDirective,
+		formatOptionsDirective,
+		aggregatesDirective,
 	}
 }
 func (*Lang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
@@ -59,7 +269,12 @@ func (*Lang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	switch cmd {
 	case "fix", "update", "update-repos":
 		conf.registerIgnoreFoldersFlag(fs)
+		conf.registerMissingImportsFlag(fs)
 	default:
 	}
+	switch cmd {
+	case "fix", "update":
+		conf.registerGraphFlags(fs)
+	}
 	c.Exts[languageName] = conf
 }
@@ -4,12 +4,79 @@
 package jsonnet
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	ignoreFoldersDirective   = "jsonnet_skip_folders"
+	missingImportsDirective  = "jsonnet_missing_imports"
+	mergeCyclesDirective     = "jsonnet_merge_cycles"
+	externalRepoDirective    = "jsonnet_external_repo"
+	importAliasDirective     = "jsonnet_import_alias"
+	localRepoDirective       = "jsonnet_local_repo"
+	rulesRepoDirective       = "jsonnet_rules_repo"
+	extVarDirective          = "jsonnet_ext_var"
+	tlaDirective             = "jsonnet_tla"
+	multipleOutputsDirective = "jsonnet_multiple_outputs"
+	outputFormatDirective    = "jsonnet_output_format"
+	variantsDirective        = "jsonnet_variants"
+	stampVarDirective        = "jsonnet_stamp_var"
+	goldenTestsDirective     = "jsonnet_golden_tests"
+	errorTestsDirective      = "jsonnet_error_tests"
+	formatTestDirective      = "jsonnet_format_test"
+	formatterDirective       = "jsonnet_formatter"
+	formatOptionsDirective   = "jsonnet_format_options"
+	aggregatesDirective      = "jsonnet_aggregates"
+)
+
+const (
+	graphOutFlag    = "jsonnet_graph_out"
+	graphFormatFlag = "jsonnet_graph_format"
+
+	graphFormatJSON = "json"
+	graphFormatDOT  = "dot"
+)
+
+const (
+	// missingImportsKeep keeps the deps of imports whose target does not exist
+	missingImportsKeep = "keep"
+	// missingImportsDrop drops the deps of imports whose target does not exist
+	missingImportsDrop = "drop"
+)
+
+const (
+	// outputFormatAuto writes YAML streams for the files evaluating to one, and JSON otherwise
+	outputFormatAuto = "auto"
+	// outputFormatJSON writes JSON
+	outputFormatJSON = "json"
+	// outputFormatYAMLStream writes YAML streams
+	outputFormatYAMLStream = "yaml_stream"
 )
 
 const (
-	ignoreFoldersDirective = "jsonnet_skip_folders"
+	// multipleOutputsOff evaluates a file as usual, rather than with jsonnet -m
+	multipleOutputsOff = "off"
+)
+
+const (
+	// Kinds of the values of external variables and top-level arguments,
+	// passed to jsonnet via --ext-<kind> and --tla-<kind>
+	varStr      = "str"
+	varCode     = "code"
+	varStrEnv   = "str_env"
+	varCodeEnv  = "code_env"
+	varStrFile  = "str_file"
+	varCodeFile = "code_file"
 )
 
 var (
@@ -56,3 +123,379 @@ func (conf *Config) registerIgnoreFoldersFlag(fs *flag.FlagSet) {
 		ignoreFoldersDirective,
 		"comma-separated list of folders that should not be processed. If not specified, Gazelle will process all the folders.")
 }
+
+// setMissingImports implements the stringFlag type so it can be used
+// to register flags
+func (conf *Config) setMissingImports(mode string) error {
+	switch mode {
+	case missingImportsKeep, missingImportsDrop:
+		conf.MissingImports = mode
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q: must be %q or %q", mode, missingImportsKeep, missingImportsDrop)
+}
+
+// ShouldDropMissingImports returns whether deps on missing imports should be dropped or not
+func (conf *Config) ShouldDropMissingImports() bool {
+	return conf.MissingImports == missingImportsDrop
+}
+func (conf *Config) registerMissingImportsFlag(fs *flag.FlagSet) {
+	fs.Var(
+		stringFlag(conf.setMissingImports),
+		missingImportsDirective,
+		"whether deps on imports of files that don't exist are kept or dropped: keep (default) or drop. Missing imports are reported in any case.")
+}
+func (conf *Config) registerGraphFlags(fs *flag.FlagSet) {
+	fs.StringVar(
+		&conf.GraphOut,
+		graphOutFlag,
+		"",
+		"path of a file where the file and target level import graphs are written. If not specified, no graph is written.")
+	fs.StringVar(
+		&conf.GraphFormat,
+		graphFormatFlag,
+		graphFormatJSON,
+		"format of the file written by -"+graphOutFlag+": json (default) or dot.")
+}
+
+// ExternalRepo maps the imports starting with a prefix to an external repository
+type ExternalRepo struct {
+	Prefix string
+	// Label is either the package of the repository where the imported files
+	// are, e.g. @repo//lib, or the target providing all of them, e.g. @repo//:lib.
+	Label  label.Label
+	Target bool
+}
+
+// addExternalRepo maps the imports starting with a prefix to an external
+// repository, from a jsonnet_external_repo value: the prefix and the package
+// or target of the repository, e.g. "ksonnet-lib @ksonnet//ksonnet-lib".
+//
+// The repository is checked against the repository rules Gazelle read from the
+// WORKSPACE file, if any. An unknown one is reported, but still mapped, as it may
+// be declared by a macro Gazelle does not read.
+func (conf *Config) addExternalRepo(repos []*rule.Rule, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return fmt.Errorf("got %q: want <import prefix> @repo//path", value)
+	}
+	repo := ExternalRepo{Prefix: path.Clean(fields[0])}
+	i := strings.Index(fields[1], "//")
+	if !strings.HasPrefix(fields[1], "@") || i < 0 {
+		return fmt.Errorf("%q: want a label in an external repository, e.g. @repo//path", fields[1])
+	}
+	if pkg := fields[1][i+2:]; strings.Contains(pkg, ":") {
+		l, err := label.Parse(fields[1])
+		if err != nil {
+			return err
+		}
+		repo.Label, repo.Target = l, true
+	} else {
+		repo.Label = label.New(fields[1][1:i], strings.TrimSuffix(pkg, "/"), "")
+	}
+	if repo.Label.Repo == "" {
+		return errors.New("empty repository name")
+	}
+
+	// A prefix can be mapped again in a subdirectory
+	found := false
+	for i, r := range conf.ExternalRepos {
+		if r.Prefix == repo.Prefix {
+			conf.ExternalRepos[i], found = repo, true
+		}
+	}
+	if !found {
+		conf.ExternalRepos = append(conf.ExternalRepos, repo)
+	}
+
+	if len(repos) == 0 {
+		return nil
+	}
+	for _, r := range repos {
+		if r.Name() == repo.Label.Repo {
+			return nil
+		}
+	}
+	return fmt.Errorf("repository @%s is not declared in the WORKSPACE file", repo.Label.Repo)
+}
+
+// ExternalImport returns the label an import resolves to, if it matches the prefix of
+// an external repository. The import is matched as written and, if it is a relative
+// one, relative to the root of the workspace, e.g. "../shared/lib.libsonnet".
+//
+// Native imports resolve to the libraries of the imported files, or to the target
+// of the repository. Data imports resolve to the imported files, and isData is set,
+// unless the repository is mapped to a target.
+func (conf *Config) ExternalImport(from fileinfo.FilePath, importstr string) (l label.Label, isData bool, found bool) {
+	candidates := []string{path.Clean(importstr)}
+	if !path.IsAbs(importstr) {
+		candidates = append(candidates, path.Join(from.Package, importstr))
+	}
+
+	var repo *ExternalRepo
+	var rest string
+	for _, candidate := range candidates {
+		for i, r := range conf.ExternalRepos {
+			if (candidate == r.Prefix || strings.HasPrefix(candidate, r.Prefix+"/")) && (repo == nil || len(r.Prefix) > len(repo.Prefix)) {
+				repo, rest = &conf.ExternalRepos[i], strings.TrimPrefix(candidate[len(r.Prefix):], "/")
+			}
+		}
+		if repo != nil {
+			break
+		}
+	}
+	if repo == nil {
+		return label.NoLabel, false, false
+	}
+	if repo.Target {
+		return repo.Label, false, true
+	}
+
+	dir, file := path.Split(rest)
+	pkg := path.Join(repo.Label.Pkg, dir)
+	if ext := path.Ext(file); conf.IsNativeImport(ext) {
+		return label.New(repo.Label.Repo, pkg, fileinfo.RuleName(strings.TrimSuffix(file, ext), libraryRulePrefix)), false, true
+	}
+	return label.New(repo.Label.Repo, pkg, file), true, true
+}
+
+// addImportAlias adds an import alias from a jsonnet_import_alias value: the
+// prefix and the workspace directory it stands for, e.g. "lib jsonnet/shared/lib".
+func (conf *Config) addImportAlias(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return fmt.Errorf("got %q: want <prefix> <workspace dir>", value)
+	}
+	alias := ImportAlias{Prefix: path.Clean(fields[0]), Dir: path.Clean(fields[1])}
+	if path.IsAbs(alias.Dir) || alias.Dir == ".." || strings.HasPrefix(alias.Dir, "../") {
+		return fmt.Errorf("%q: want a directory relative to the root of the workspace", fields[1])
+	}
+
+	// A prefix can be aliased again in a subdirectory
+	for i, a := range conf.ImportAliases {
+		if a.Prefix == alias.Prefix {
+			conf.ImportAliases[i] = alias
+			return nil
+		}
+	}
+	conf.ImportAliases = append(conf.ImportAliases, alias)
+	return nil
+}
+
+// addLocalRepo names the nested repository rooted in a workspace directory, from
+// a jsonnet_local_repo value, e.g. "third_party/mixins mixins".
+func (conf *Config) addLocalRepo(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return fmt.Errorf("got %q: want <workspace dir> <repository name>", value)
+	}
+	dir := path.Clean(fields[0])
+	if path.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("%q: want a directory relative to the root of the workspace", fields[0])
+	}
+	conf.LocalRepos[dir] = strings.TrimPrefix(fields[1], "@")
+	return nil
+}
+
+// addLocalRepos registers the local_repository rules of the WORKSPACE whose
+// path is a directory of the workspace.
+func (conf *Config) addLocalRepos(root string, repos []*rule.Rule) {
+	for _, r := range repos {
+		dir := r.AttrString("path")
+		if r.Kind() != "local_repository" || r.Name() == "" || dir == "" {
+			continue
+		}
+		if filepath.IsAbs(dir) {
+			rel, ok := fileinfo.Rel(root, dir)
+			if !ok {
+				continue
+			}
+			dir = rel
+		}
+		dir = path.Clean(filepath.ToSlash(dir))
+		if dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+			continue
+		}
+		conf.LocalRepos[dir] = r.Name()
+	}
+}
+
+// NestedImport returns the label an imported file resolves to, if it is in a nested
+// repository, i.e. a directory of the workspace with its own WORKSPACE or MODULE.bazel.
+// Files importing each other within the same nested repository are left alone.
+//
+// Native imports resolve to the libraries of the imported files. Data imports resolve
+// to the imported files, and isData is set. It fails if the repository has no name.
+func (conf *Config) NestedImport(from fileinfo.FilePath, abs string) (l label.Label, isData bool, found bool, err error) {
+	dir, found := fileinfo.RepoBoundary(from.Root, abs)
+	if !found {
+		return label.NoLabel, false, false, nil
+	}
+	if fromDir, ok := fileinfo.RepoBoundary(from.Root, from.Abs()); ok && fromDir == dir {
+		return label.NoLabel, false, false, nil
+	}
+	name := conf.LocalRepos[dir]
+	if name == "" {
+		return label.NoLabel, false, false, fmt.Errorf("%s is a nested repository without name: declare it with local_repository or the %s directive", dir, localRepoDirective)
+	}
+
+	rel, _ := fileinfo.Rel(filepath.Join(from.Root, dir), abs)
+	pkg, file := path.Split(filepath.ToSlash(rel))
+	pkg = strings.TrimSuffix(pkg, "/")
+	if ext := path.Ext(file); conf.IsNativeImport(ext) {
+		return label.New(name, pkg, fileinfo.RuleName(strings.TrimSuffix(file, ext), libraryRulePrefix)), false, true, nil
+	}
+	return label.New(name, pkg, file), true, true, nil
+}
+
+// VarValue is the value of an external variable or of a top-level argument
+type VarValue struct {
+	Kind string // One of str, code, str_env, code_env, str_file and code_file
+	// Value is the string or the code of the variable, or the label of its file.
+	// Variables read from the environment have none, as the environment variable
+	// is named after them.
+	Value string
+}
+
+// parseVarValue parses the name of a variable, the kind of its value, which must be
+// one of the given kinds, and the value itself.
+func parseVarValue(value string, kinds ...string) (string, VarValue, error) {
+	name, rest := splitField(value)
+	kind, val := splitField(rest)
+	if name == "" || kind == "" {
+		return "", VarValue{}, fmt.Errorf("got %q: want <name> <kind> [<value>]", value)
+	}
+	known := false
+	for _, k := range kinds {
+		known = known || k == kind
+	}
+	if !known {
+		return "", VarValue{}, fmt.Errorf("%s: unknown kind %q: must be one of %s", name, kind, strings.Join(kinds, ", "))
+	}
+	switch kind {
+	case varStrEnv, varCodeEnv:
+		if val != "" {
+			return "", VarValue{}, fmt.Errorf("%s: %s variables are read from the environment variable named after them: want no value", name, kind)
+		}
+	case varStrFile, varCodeFile:
+		if _, err := label.Parse(val); err != nil {
+			return "", VarValue{}, fmt.Errorf("%s: want the label of a file: %v", name, err)
+		}
+	}
+	return name, VarValue{Kind: kind, Value: val}, nil
+}
+
+// addExtVar sets the value of an external variable from a jsonnet_ext_var value:
+// its name, the kind of its value and the value itself, e.g. "env str dev",
+// "replicas code 3", "user str_env" or "config code_file //config:prod.libsonnet".
+// The variable is no longer stamped.
+func (conf *Config) addExtVar(value string) error {
+	name, v, err := parseVarValue(value, varStr, varCode, varStrEnv, varCodeEnv, varStrFile, varCodeFile)
+	if err != nil {
+		return err
+	}
+	// A variable can be set again in a subdirectory
+	conf.ExtVars[name] = v
+	delete(conf.StampVars, name)
+	return nil
+}
+
+// addStampVar stamps an external variable from a jsonnet_stamp_var value: the
+// name of the variable, given the value of the workspace status key of the same
+// name, and the kind of its value, str by default, e.g. "BUILD_SCM_REVISION" or
+// "BUILD_TIMESTAMP code".
+func (conf *Config) addStampVar(value string) error {
+	name, kind := splitField(value)
+	if kind == "" {
+		kind = varStr
+	}
+	if name == "" || strings.ContainsAny(kind, " \t") {
+		return fmt.Errorf("got %q: want <name> [%s|%s]", value, varStr, varCode)
+	}
+	if kind != varStr && kind != varCode {
+		return fmt.Errorf("%s: unknown kind %q: must be %s or %s", name, kind, varStr, varCode)
+	}
+	// rules_jsonnet replaces the {KEY} values of the stamped variables
+	conf.ExtVars[name] = VarValue{Kind: kind, Value: "{" + name + "}"}
+	conf.StampVars[name] = true
+	return nil
+}
+
+// addTLA sets the value of a top-level argument from a jsonnet_tla value: its
+// name, the kind of its value and the value itself, e.g. "env str dev",
+// "replicas code 3" or "config code_file //config:prod.libsonnet".
+func (conf *Config) addTLA(value string) error {
+	name, v, err := parseVarValue(value, varStr, varCode, varCodeFile)
+	if err != nil {
+		return err
+	}
+	// An argument can be set again in a subdirectory
+	conf.TLAs[name] = v
+	return nil
+}
+
+// addMultipleOutputs takes a file of a package and the files it writes when evaluated
+// with jsonnet -m, e.g. "main.jsonnet deployment.json service.json", or off to evaluate
+// it as usual, e.g. "config.jsonnet off".
+func (conf *Config) addMultipleOutputs(rel, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return fmt.Errorf("got %q: want <file> <output>... or <file> %s", value, multipleOutputsOff)
+	}
+	outputs := []string{}
+	if len(fields) != 2 || fields[1] != multipleOutputsOff {
+		for _, out := range fields[1:] {
+			if path.Clean(out) != out || path.IsAbs(out) || out == ".." || strings.HasPrefix(out, "../") {
+				return fmt.Errorf("%q: want a file relative to the package", out)
+			}
+			outputs = append(outputs, out)
+		}
+		sort.Strings(outputs)
+	}
+	conf.ForcedOutputs[path.Join(rel, fields[0])] = outputs
+	return nil
+}
+
+// MultipleOutputs returns the files written by a file evaluated with jsonnet -m, if it
+// is: the ones set with the jsonnet_multiple_outputs directive, if any, or the keys of
+// the object it evaluates to, if they are all filenames.
+func (conf *Config) MultipleOutputs(info fileinfo.FileInfo) []string {
+	if outputs, found := conf.ForcedOutputs[info.Path.Path]; found {
+		return outputs
+	}
+	return info.Outputs
+}
+
+// setOutputFormat sets the format of the outputs of jsonnet_to_json rules from
+// a jsonnet_output_format value: auto, json or yaml_stream
+func (conf *Config) setOutputFormat(format string) error {
+	switch format {
+	case outputFormatAuto, outputFormatJSON, outputFormatYAMLStream:
+		conf.OutputFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown format %q: must be %q, %q or %q", format, outputFormatAuto, outputFormatJSON, outputFormatYAMLStream)
+}
+
+// IsYAMLStream returns whether a file is written as a stream of YAML documents:
+// if the file evaluates to one, unless the format is set with the
+// jsonnet_output_format directive.
+func (conf *Config) IsYAMLStream(info fileinfo.FileInfo) bool {
+	switch conf.OutputFormat {
+	case outputFormatJSON:
+		return false
+	case outputFormatYAMLStream:
+		return true
+	}
+	return info.YAMLStream
+}
+
+// splitField returns the first whitespace-separated field of a string, and the
+// rest of the string with leading and trailing whitespace removed.
+func splitField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
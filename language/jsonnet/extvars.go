@@ -0,0 +1,278 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+var (
+	// extVarAttrs are the attributes of jsonnet_to_json passing external variables
+	extVarAttrs = []string{
+		"ext_strs",
+		"ext_code",
+		"ext_str_envs",
+		"ext_code_envs",
+		"ext_str_files",
+		"ext_str_file_vars",
+		"ext_code_files",
+		"ext_code_file_vars",
+		"stamp_keys",
+	}
+)
+
+// extVars returns the external variables read by a file and, transitively, by the
+// files it imports, along with the position of their first occurrence. Files of
+// external repositories are not parsed.
+func (l *Lang) extVars(c *config.Config, info fileinfo.FileInfo) map[string]fileinfo.Position {
+	vars := map[string]fileinfo.Position{}
+	seen := map[string]bool{info.Path.Path: true}
+	queue := []fileinfo.FileInfo{info}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for name, pos := range current.ExtVars {
+			if _, found := vars[name]; !found {
+				vars[name] = pos
+			}
+		}
+
+		imports := make([]string, 0, len(current.Imports))
+		for imp := range current.Imports {
+			imports = append(imports, imp)
+		}
+		sort.Strings(imports)
+		for _, imp := range imports {
+			if seen[imp] {
+				continue
+			}
+			seen[imp] = true
+			if imported, ok := l.importedFile(c, current.Imports[imp]); ok {
+				queue = append(queue, imported)
+			}
+		}
+	}
+	return vars
+}
+
+// importedFile returns the FileInfo of an imported file. Files whose package was
+// not visited yet are parsed with the configuration of their directory, and kept
+// until it is.
+//
+// Directories are configured top-down, but visited bottom-up. The directories of
+// the files imported from another subtree may not be configured yet: these files
+// are parsed with the configuration of their closest configured parent, without
+// the directives set in the build files in between, e.g. import aliases. They are
+// not kept, as they are parsed again once their directory is configured.
+func (l *Lang) importedFile(c *config.Config, fpath fileinfo.FilePath) (fileinfo.FileInfo, bool) {
+	if info, found := l.graph.File(fpath.Path); found {
+		return info, true
+	}
+	info, found := l.parsed[fpath.Path]
+	if !found {
+		fc, configured := l.configOf(c, fpath.Package)
+		// Errors are reported when the package of the file is visited
+		info, _ = NewFileInfo(fc, filepath.Join(fpath.Root, fpath.Package), fpath.Package, fpath.Filename, &Importer{l.Importer})
+		if configured {
+			l.parsed[fpath.Path] = info
+		}
+	}
+	if info == nil {
+		return fileinfo.FileInfo{}, false
+	}
+	return *info, true
+}
+
+// configOf returns the configuration of a directory or, if it is not configured
+// yet, the one of its closest configured parent, and whether it is its own.
+func (l *Lang) configOf(c *config.Config, rel string) (*config.Config, bool) {
+	if dc, found := l.configs[rel]; found {
+		return dc, true
+	}
+	for dir := rel; dir != ""; {
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+		if dc, found := l.configs[dir]; found {
+			return dc, false
+		}
+	}
+	return c, false
+}
+
+// setExtVarAttrs sets the attributes of a jsonnet_to_json rule passing the values
+// of the external variables read by its file. Variables without value are reported.
+func setExtVarAttrs(conf *Config, r *rule.Rule, from fileinfo.FilePath, vars map[string]fileinfo.Position) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	strs, code := map[string]string{}, map[string]string{}
+	var strEnvs, codeEnvs, strFiles, strFileVars, codeFiles, codeFileVars, stampKeys []string
+	for _, name := range names {
+		v, found := conf.ExtVars[name]
+		if !found {
+			log.Printf("%s: external variable %q has no value to evaluate %s: set it with the %s directive", vars[name], name, from.Path, extVarDirective)
+			continue
+		}
+		if conf.StampVars[name] {
+			stampKeys = append(stampKeys, name)
+		}
+		switch v.Kind {
+		case varStr:
+			strs[name] = v.Value
+		case varCode:
+			code[name] = v.Value
+		case varStrEnv:
+			strEnvs = append(strEnvs, name)
+		case varCodeEnv:
+			codeEnvs = append(codeEnvs, name)
+		case varStrFile:
+			strFiles, strFileVars = append(strFiles, v.Value), append(strFileVars, name)
+		case varCodeFile:
+			codeFiles, codeFileVars = append(codeFiles, v.Value), append(codeFileVars, name)
+		}
+	}
+
+	if len(strs) > 0 {
+		r.SetAttr("ext_strs", strs)
+	}
+	if len(code) > 0 {
+		r.SetAttr("ext_code", code)
+	}
+	// The lists of files and of their variables are matched by index
+	for key, values := range map[string][]string{
+		"ext_str_envs":       strEnvs,
+		"ext_code_envs":      codeEnvs,
+		"ext_str_files":      strFiles,
+		"ext_str_file_vars":  strFileVars,
+		"ext_code_files":     codeFiles,
+		"ext_code_file_vars": codeFileVars,
+		"stamp_keys":         stampKeys,
+	} {
+		if len(values) > 0 {
+			r.SetAttr(key, values)
+		}
+	}
+}
+
+// syncAttrs sets attributes of a generated rule on the rule of the same name and kind
+// in a build file, if any. They are the attributes Gazelle cannot merge, i.e. dicts,
+// and lists matched by index with another one. Attributes annotated with "# keep" are
+// left alone, and so are the entries of dicts, which are merged with the generated ones.
+// Lists with an entry annotated with "# keep" are left alone as a whole.
+func syncAttrs(f *rule.File, r *rule.Rule, keys ...string) {
+	old := findRule(f, r.Kind(), r.Name())
+	if old == nil || old.ShouldKeep() {
+		return
+	}
+	for _, key := range keys {
+		if isKeptAttr(f, old, key) {
+			continue
+		}
+		value := r.Attr(key)
+		if dict, ok := old.Attr(key).(*bzl.DictExpr); ok {
+			value = mergeKeptEntries(dict, value)
+		}
+		if value == nil {
+			old.DelAttr(key)
+		} else {
+			old.SetAttr(key, value)
+		}
+	}
+}
+
+// findRule returns the rule of a build file with the given kind and name, if any
+func findRule(f *rule.File, kind, name string) *rule.Rule {
+	if f == nil {
+		return nil
+	}
+	for _, r := range f.Rules {
+		if r.Kind() == kind && r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// isKeptAttr returns whether an attribute of a rule is annotated with "# keep", on
+// its assignment or its value, or whether it is a list with a kept entry.
+func isKeptAttr(f *rule.File, r *rule.Rule, key string) bool {
+	value := r.Attr(key)
+	if value == nil {
+		return false
+	}
+	if rule.ShouldKeep(value) {
+		return true
+	}
+	if list, ok := value.(*bzl.ListExpr); ok {
+		for _, e := range list.List {
+			if rule.ShouldKeep(e) {
+				return true
+			}
+		}
+	}
+	// Comments after the value are attached to the assignment
+	for _, stmt := range f.File.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		for _, arg := range call.List {
+			if assign, ok := arg.(*bzl.AssignExpr); ok && assign.RHS == value {
+				return rule.ShouldKeep(assign)
+			}
+		}
+	}
+	return false
+}
+
+// mergeKeptEntries returns a dict with the entries of a generated dict, if any,
+// and the entries of an existing one annotated with "# keep", which take precedence.
+func mergeKeptEntries(old *bzl.DictExpr, gen bzl.Expr) bzl.Expr {
+	var kept []bzl.Expr
+	keys := map[string]bool{}
+	for _, e := range old.List {
+		kv, ok := e.(*bzl.KeyValueExpr)
+		if !ok || !rule.ShouldKeep(kv) && !rule.ShouldKeep(kv.Value) {
+			continue
+		}
+		kept = append(kept, kv)
+		keys[dictKey(kv)] = true
+	}
+	if len(kept) == 0 {
+		return gen
+	}
+
+	merged := &bzl.DictExpr{List: kept, ForceMultiLine: true}
+	if dict, ok := gen.(*bzl.DictExpr); ok {
+		for _, e := range dict.List {
+			if kv, ok := e.(*bzl.KeyValueExpr); ok && !keys[dictKey(kv)] {
+				merged.List = append(merged.List, kv)
+			}
+		}
+	}
+	sort.SliceStable(merged.List, func(i, j int) bool {
+		return dictKey(merged.List[i].(*bzl.KeyValueExpr)) < dictKey(merged.List[j].(*bzl.KeyValueExpr))
+	})
+	return merged
+}
+
+// dictKey returns the key of a dict entry, if it is a string
+func dictKey(kv *bzl.KeyValueExpr) string {
+	if k, ok := kv.Key.(*bzl.StringExpr); ok {
+		return k.Value
+	}
+	return ""
+}
@@ -0,0 +1,274 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestExtVars(t *testing.T) {
+	directives := `# gazelle:jsonnet_ext_var env str dev
+# gazelle:jsonnet_ext_var replicas code 3
+# gazelle:jsonnet_ext_var token str_env
+# gazelle:jsonnet_ext_var config code_file //config:prod.libsonnet
+# gazelle:jsonnet_ext_var region str eu-west-1
+`
+	testCases := []struct {
+		desc, old, want string
+	}{
+		{
+			desc: "new",
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {
+        "replicas": "3",
+    },
+    ext_code_file_vars = ["config"],
+    ext_code_files = ["//config:prod.libsonnet"],
+    ext_str_envs = ["token"],
+    ext_strs = {
+        "env": "dev",
+        "region": "eu-west-1",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		}, {
+			desc: "stale",
+			old: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {"replicas": "1"},
+    ext_str_envs = ["token"],
+    ext_str_files = ["//config:old.txt"],
+    ext_str_file_vars = ["old"],
+    ext_strs = {
+        "env": "prod",
+        "old": "value",
+    },
+    deps = ["//app:main_library"],
+)
+`,
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {
+        "replicas": "3",
+    },
+    ext_code_file_vars = ["config"],
+    ext_code_files = ["//config:prod.libsonnet"],
+    ext_str_envs = ["token"],
+    ext_strs = {
+        "env": "dev",
+        "region": "eu-west-1",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		}, {
+			desc: "keep",
+			old: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {"replicas": "1"},  # keep
+    ext_strs = {
+        "env": "prod",  # keep
+        "extra": "value",  # keep
+        "old": "value",
+    },
+    deps = ["//app:main_library"],
+)
+`,
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {"replicas": "1"},  # keep
+    ext_code_file_vars = ["config"],
+    ext_code_files = ["//config:prod.libsonnet"],
+    ext_str_envs = ["token"],
+    ext_strs = {
+        "env": "prod",  # keep
+        "extra": "value",  # keep
+        "region": "eu-west-1",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": `local region = import '../lib/region.libsonnet';
+{
+  env: std.extVar('env'),
+  replicas: std.extVar('replicas'),
+  token: std.extVar('token'),
+  config: std.extVar('config'),
+  region: region,
+}`,
+		// The package of the library is not visited
+		"lib/region.libsonnet": "std.extVar('region')",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", directives+tc.old)
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_to_json" {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStampVars(t *testing.T) {
+	directives := `# gazelle:jsonnet_stamp_var BUILD_SCM_REVISION
+# gazelle:jsonnet_stamp_var BUILD_TIMESTAMP code
+# gazelle:jsonnet_stamp_var BUILD_USER
+`
+	want := `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_code = {
+        "BUILD_TIMESTAMP": "{BUILD_TIMESTAMP}",
+    },
+    ext_strs = {
+        "BUILD_SCM_REVISION": "{BUILD_SCM_REVISION}",
+    },
+    stamp_keys = [
+        "BUILD_SCM_REVISION",
+        "BUILD_TIMESTAMP",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": `local version = import '../lib/version.libsonnet';
+{
+  version: version,
+  timestamp: std.extVar('BUILD_TIMESTAMP'),
+}`,
+		"lib/version.libsonnet": "std.extVar('BUILD_SCM_REVISION')",
+	})
+	defer os.RemoveAll(root)
+
+	f := update(t, root, "app", directives)
+	for _, r := range f.Rules {
+		if r.Kind() != "jsonnet_to_json" {
+			r.Delete()
+		}
+	}
+	got := strings.TrimPrefix(string(f.Format()), directives)
+	if strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExtVarsImportedConfig(t *testing.T) {
+	root := writeWorkspace(t, map[string]string{
+		"lib/env.libsonnet":                   "import 'common/vars.libsonnet'",
+		"lib/shared/common/vars.libsonnet":    "{ region: std.extVar('region') }",
+		"lib/app/main.jsonnet":                "import '../env.libsonnet'",
+		"lib/app/local/common/vars.libsonnet": "{ zone: std.extVar('zone') }",
+		"app/main.jsonnet":                    "import '../lib/env.libsonnet'",
+		"zapp/main.jsonnet":                   "import '../lib/env.libsonnet'",
+	})
+	defer os.RemoveAll(root)
+	builds := map[string]string{
+		"":        "# gazelle:jsonnet_ext_var region str eu\n# gazelle:jsonnet_ext_var zone str a\n",
+		"lib":     "# gazelle:jsonnet_import_alias common lib/shared/common\n",
+		"lib/app": "# gazelle:jsonnet_import_alias common lib/app/local/common\n",
+	}
+
+	lang := jsonnet.NewLanguage()
+	configs := map[string]*config.Config{}
+	configure := func(rel string) {
+		c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+		if parent := path.Dir(rel); rel != "" && parent == "." {
+			c = configs[""].Clone()
+		} else if rel != "" {
+			c = configs[parent].Clone()
+		}
+		var f *rule.File
+		if build, found := builds[rel]; found {
+			var err error
+			if f, err = rule.LoadData(filepath.Join(root, rel, "BUILD.bazel"), rel, []byte(build)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lang.Configure(c, rel, f)
+		configs[rel] = c
+	}
+	// generate returns the external variables of the jsonnet_to_json rule of a package
+	generate := func(rel string, files ...string) []string {
+		res := lang.GenerateRules(language.GenerateArgs{
+			Config:       configs[rel],
+			Dir:          filepath.Join(root, rel),
+			Rel:          rel,
+			RegularFiles: files,
+		})
+		for _, r := range res.Gen {
+			if r.Kind() == "jsonnet_to_json" {
+				var vars []string
+				if strs, ok := r.Attr("ext_strs").(*bzl.DictExpr); ok {
+					for _, kv := range strs.List {
+						vars = append(vars, kv.(*bzl.KeyValueExpr).Key.(*bzl.StringExpr).Value)
+					}
+				}
+				return vars
+			}
+		}
+		return nil
+	}
+
+	// Directories are configured top-down, and visited bottom-up, as Gazelle does
+	configure("")
+	configure("app")
+	// The directory of the imported file is not configured yet: the alias of
+	// lib/BUILD.bazel is not applied.
+	if got := generate("app", "main.jsonnet"); len(got) != 0 {
+		t.Errorf("app: got variables %q; want none", got)
+	}
+	configure("lib")
+	configure("lib/app")
+	// The imported file is parsed with the alias of its own directory, rather
+	// than the one of the importing file.
+	if got, want := generate("lib/app", "main.jsonnet"), []string{"region"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lib/app: got variables %q; want %q", got, want)
+	}
+	generate("lib", "env.libsonnet")
+	configure("zapp")
+	if got, want := generate("zapp", "main.jsonnet"), []string{"region"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("zapp: got variables %q; want %q", got, want)
+	}
+}
@@ -4,11 +4,16 @@
 package jsonnet
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
 )
 
@@ -24,25 +29,118 @@ func NewFileInfo(c *config.Config, dir string, rel string, name string, importer
 		Path:        path,
 		Imports:     make(map[string]fileinfo.FilePath),
 		DataImports: make(map[string]fileinfo.FilePath),
+		Positions:   make(map[string]fileinfo.Position),
+		Kinds:       make(map[string]fileinfo.ImportKind),
+		ImportStrs:  make(map[string]string),
+		Missing:     make(map[string]bool),
+
+		External:     make(map[string]label.Label),
+		ExternalData: make(map[string]label.Label),
+		ExtVars:      make(map[string]fileinfo.Position),
 	}
 
 	if !conf.IsNativeImport(path.Ext) {
 		return nil, nil
 	}
 
-	imports, err := ParseFileImports(path.Abs(), importer)
+	snippet, err := ParseFile(path.Abs(), importer)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing file %q: %v", path.Filename, err)
 	}
 
-	for _, filename := range imports {
-		abs, err := NormalizeImport(path, filename)
+	for _, v := range snippet.ExtVars {
+		v.Pos.Filename = path.Path
+		info.ExtVars[v.Name] = v.Pos
+	}
+	info.Outputs = snippet.Outputs
+	info.YAMLStream = snippet.YAMLStream
+	for _, a := range snippet.Annotations {
+		a.Pos.Filename = path.Path
+		info.Annotations = append(info.Annotations, a)
+	}
+	if snippet.Function != nil {
+		info.Function = snippet.Function
+		info.Function.Pos.Filename = path.Path
+	}
+
+	roots := map[string]bool{}
+	for _, imp := range snippet.Imports {
+		// Report positions relative to the workspace rather than the absolute
+		// file name the parser was given.
+		imp.Pos.Filename = path.Path
+		abs, err := NormalizeImport(path, imp.File, conf.ImportAliases...)
+		// jsonnet finds aliased imports in the root of their alias, if any
+		if a, _, found := MatchImportAlias(conf.ImportAliases, imp.File); found {
+			if root, ok := a.Root(); ok {
+				roots[root] = true
+			} else {
+				log.Print(ImportError{
+					Pos:    imp.Pos,
+					Kind:   imp.Kind,
+					Import: imp.File,
+					Err:    fmt.Errorf("jsonnet cannot evaluate the alias %s of %s: the directory must end with the alias", a.Prefix, a.Dir),
+				})
+			}
+		}
+		// Imports not found next to the file are looked up in the vendor directory
+		// of jsonnet-bundler, which jsonnet is given as a library path.
+		if err == nil && !exists(abs) {
+			if vendored, found := conf.Bundle.ResolveImport(imp.File); found {
+				abs = filepath.Join(path.Root, vendored)
+			}
+		}
+		// Otherwise, they may be provided by an external repository
+		if err != nil || !exists(abs) {
+			if l, isData, found := conf.ExternalImport(path, imp.File); found {
+				if _, found := info.Positions[imp.File]; !found {
+					info.Positions[imp.File] = imp.Pos
+					info.Kinds[imp.File] = imp.Kind
+					info.ImportStrs[imp.File] = imp.File
+				}
+				if isData {
+					info.ExternalData[imp.File] = l
+				} else {
+					info.External[imp.File] = l
+				}
+				continue
+			}
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error normalizing import %q: %v", filename, err)
+			return nil, ImportError{Pos: imp.Pos, Kind: imp.Kind, Import: imp.File, Err: err}
+		}
+		// Files of nested repositories are labeled within their repository
+		if l, isData, found, err := conf.NestedImport(path, abs); err != nil {
+			log.Print(ImportError{Pos: imp.Pos, Kind: imp.Kind, Import: imp.File, Err: err})
+		} else if found {
+			if _, found := info.Positions[imp.File]; !found {
+				info.Positions[imp.File] = imp.Pos
+				info.Kinds[imp.File] = imp.Kind
+				info.ImportStrs[imp.File] = imp.File
+			}
+			if isData {
+				info.ExternalData[imp.File] = l
+			} else {
+				info.External[imp.File] = l
+			}
+			continue
 		}
 		importPath, err := fileinfo.NewFilePath(path.Root, abs)
 		if err != nil {
-			return nil, err
+			return nil, ImportError{Pos: imp.Pos, Kind: imp.Kind, Import: imp.File, Err: err}
+		}
+
+		// Different import strings may lead to the same file, e.g. 'a.jsonnet'
+		// and './a.jsonnet'. Keep the first position only.
+		if _, found := info.Positions[importPath.Path]; !found {
+			info.Positions[importPath.Path] = imp.Pos
+			info.Kinds[importPath.Path] = imp.Kind
+			info.ImportStrs[importPath.Path] = imp.File
+		}
+
+		// The target may still be generated by a rule, which we don't know about
+		// until all the packages are visited. Mark it, so it can be checked later.
+		if _, err := os.Stat(importPath.Abs()); os.IsNotExist(err) {
+			info.Missing[importPath.Path] = true
 		}
 
 		if conf.IsNativeImport(importPath.Ext) {
@@ -53,9 +151,35 @@ func NewFileInfo(c *config.Config, dir string, rel string, name string, importer
 		info.DataImports[importPath.Path] = importPath
 	}
 
+	for root := range roots {
+		info.ImportRoots = append(info.ImportRoots, root)
+	}
+	sort.Strings(info.ImportRoots)
+
 	return info, nil
 }
 
+// ImportError locates an error related to an import expression
+type ImportError struct {
+	Pos    fileinfo.Position   // Location of the import expression
+	Kind   fileinfo.ImportKind // Kind of the import expression, import if unset
+	Import string              // Imported file name, as written
+	Err    error
+}
+
+func (e ImportError) Error() string {
+	kind := e.Kind
+	if kind == "" {
+		kind = fileinfo.Import
+	}
+	return fmt.Sprintf("%s: %s %q: %v", e.Pos, kind, e.Import, e.Err)
+}
+
+// Unwrap implements errors.Unwrap
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
 // OutOfWorkspaceError defines a typed error for this specific case
 type OutOfWorkspaceError string
 
@@ -69,18 +193,64 @@ func (e OutOfWorkspaceError) Is(t error) bool {
 	return ok
 }
 
+// ImportAlias maps the imports starting with a prefix to a workspace directory
+type ImportAlias struct {
+	Prefix string // Logical prefix, e.g. lib
+	Dir    string // Workspace-relative directory, e.g. jsonnet/shared/lib
+}
+
+// Root returns the workspace-relative directory jsonnet must be given as
+// library path to find the imports of the alias. It only exists when the
+// directory ends with the prefix, e.g. jsonnet/shared for the example above.
+func (a ImportAlias) Root() (string, bool) {
+	switch {
+	case a.Dir == a.Prefix:
+		return "", true
+	case strings.HasSuffix(a.Dir, "/"+a.Prefix):
+		return strings.TrimSuffix(a.Dir, "/"+a.Prefix), true
+	}
+	return "", false
+}
+
+// MatchImportAlias returns the alias with the longest prefix matching an import
+// string, and the rest of the import string.
+func MatchImportAlias(aliases []ImportAlias, importstr string) (ImportAlias, string, bool) {
+	var found ImportAlias
+	var rest string
+	for _, a := range aliases {
+		if len(a.Prefix) <= len(found.Prefix) {
+			continue
+		}
+		if importstr == a.Prefix || strings.HasPrefix(importstr, a.Prefix+"/") {
+			found, rest = a, strings.TrimPrefix(importstr[len(a.Prefix):], "/")
+		}
+	}
+	return found, rest, found.Prefix != ""
+}
+
 // NormalizeImport normalizes an import string to be absolute, in any case.
 // E.g. import '../foo.jsonnet' => import '/abs/to/foo.jsonnet'
-func NormalizeImport(path fileinfo.FilePath, importstr string) (string, error) {
-	if filepath.IsAbs(importstr) {
-		if !strings.HasPrefix(importstr, path.Root) {
+//
+// Import aliases are applied before, e.g. with the alias lib to jsonnet/shared/lib:
+// import 'lib/foo.libsonnet' => import '/abs/to/jsonnet/shared/lib/foo.libsonnet'
+//
+// Symlinked directories are resolved, so the import belongs to the package of its
+// target. Imports out of the workspace fail with an OutOfWorkspaceError, and imports
+// through symlinks leading out of the workspace with a *fileinfo.RootError.
+func NormalizeImport(path fileinfo.FilePath, importstr string, aliases ...ImportAlias) (string, error) {
+	joined := importstr
+	if a, rest, found := MatchImportAlias(aliases, importstr); found {
+		joined = filepath.Join(path.Root, a.Dir, rest)
+	} else if !filepath.IsAbs(importstr) {
+		joined = path.Join(importstr)
+	}
+	abs, err := fileinfo.Canonical(path.Root, joined)
+	if err != nil {
+		var rerr *fileinfo.RootError
+		if errors.As(err, &rerr) && rerr.Link == "" {
 			return "", OutOfWorkspaceError(importstr)
 		}
-		return importstr, nil
-	}
-	joined := path.Join(importstr)
-	if !strings.HasPrefix(joined, path.Root) {
-		return "", OutOfWorkspaceError(importstr)
+		return "", fmt.Errorf("cannot normalize %q: %w", importstr, err)
 	}
-	return joined, nil
+	return abs, nil
 }
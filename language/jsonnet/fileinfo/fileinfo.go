@@ -26,11 +26,82 @@ type FilePath struct {
 	Path     string // File path, relative to the root of the workspace
 }
 
+// Position locates an expression within a file
+type Position struct {
+	Filename string // File path, relative to the root of the workspace
+	Line     int    // Line number, starting at 1
+	Column   int    // Column number, starting at 1
+}
+
+// ImportKind is the kind of expression importing a file
+type ImportKind string
+
+// Kinds of import expressions
+const (
+	Import    ImportKind = "import"
+	ImportStr ImportKind = "importstr"
+)
+
 // FileInfo contains metadata extracted from a file
 type FileInfo struct {
-	Path        FilePath            // File path information
-	Imports     map[string]FilePath // Jsonnet imports, from import
-	DataImports map[string]FilePath // Data imports, from importstr
+	Path        FilePath              // File path information
+	Imports     map[string]FilePath   // Jsonnet imports, from import
+	DataImports map[string]FilePath   // Data imports, from importstr
+	Positions   map[string]Position   // Source positions of the imports, keyed by import path
+	Kinds       map[string]ImportKind // Kinds of the import expressions, keyed by import path
+	ImportStrs  map[string]string     // Import strings, as written, keyed by import path
+	Missing     map[string]bool       // Imports whose target does not exist in the workspace
+
+	// Imports resolved to external repositories are keyed by import, as written.
+	// Positions, Kinds and ImportStrs are keyed the same way for them.
+	External     map[string]label.Label // Libraries of external repositories
+	ExternalData map[string]label.Label // Data files of external repositories
+
+	// Workspace-relative directories jsonnet must be given as library paths,
+	// e.g. the roots of import aliases. Sorted.
+	ImportRoots []string
+
+	// External variables read with std.extVar, keyed by name. Positions are the
+	// ones of their first occurrence.
+	ExtVars map[string]Position
+
+	// Function the file evaluates to, if any
+	Function *Function
+	// Files written by jsonnet -m, i.e. the keys of the object the file evaluates
+	// to, if they are all literal filenames. Sorted.
+	Outputs []string
+	// Whether the file evaluates to a stream of YAML documents, i.e. a call to
+	// std.manifestYamlStream or an array of Kubernetes objects
+	YAMLStream bool
+
+	// Settings of the file, from its "// gazelle:key value" comments, in order
+	Annotations []Annotation
+}
+
+// Annotation is a setting of a file, from a "// gazelle:key value" comment at
+// the top of the file, e.g. "// gazelle:output deployment.yaml"
+type Annotation struct {
+	Key   string
+	Value string
+	Pos   Position // Location of the comment
+}
+
+// Function is a function a file evaluates to. jsonnet calls it with the top-level
+// arguments given for its parameters.
+type Function struct {
+	Pos    Position // Location of the function
+	Params []Param  // Parameters, in order
+}
+
+// Param is a parameter of a function
+type Param struct {
+	Name       string
+	HasDefault bool // Parameters with a default value need no argument
+}
+
+// String returns the position in the file:line:col format understood by editors
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 // Join filepath.Joins any number of path elements into a single path prepending
@@ -41,8 +112,13 @@ func (fp FilePath) Join(elem ...string) string {
 
 // RuleName computes a rule name for a given file path
 func (fp FilePath) RuleName(prefix string) string {
+	return RuleName(fp.Name, prefix)
+}
+
+// RuleName computes a rule name for a given name, e.g. a file name without extension
+func RuleName(name, prefix string) string {
 	// Replace non [a-zA-Z0-9_] characters with "_"
-	str := ruleRe.ReplaceAllString(strings.ToLower(fp.Name), "_")
+	str := ruleRe.ReplaceAllString(strings.ToLower(name), "_")
 	return str + "_" + prefix
 }
 
@@ -68,24 +144,26 @@ func (fp FilePath) Abs() string {
 
 // NewFilePath constructs a FilePath structure given a root directory and one or more path elements.
 //
-// The path elements are filepath.Join-ed together interpreted as relative to root.
+// The path elements are filepath.Join-ed together interpreted as relative to root, unless
+// they form an absolute path.
 //
-// If the path elements forms an absolute path, NewFilePath returns a FilePath structure
-// so Package is relative to the root directory. Therefore, Package will contain as many ".." symbols
-// in order to "escape" from its absolute path and then "join" the root directory.
+// The path is resolved with Canonical, so files reached through symlinked directories belong
+// to the package of their target. NewFilePath fails with a *RootError if the path is out of
+// the root directory.
 func NewFilePath(root string, elem ...string) (FilePath, error) {
 	// We don't know the elements shape so let's join them and then split them
 	// into dir and file
 	path := filepath.Join(elem...)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
 
-	// Get rid of the root part in absolute paths
-	if filepath.IsAbs(path) {
-		p, err := filepath.Rel(root, path)
-		if err != nil {
-			return FilePath{}, err
-		}
-		path = p
+	abs, err := Canonical(root, path)
+	if err != nil {
+		return FilePath{}, err
 	}
+	// Get rid of the root part
+	path, _ = Rel(root, abs)
 
 	dir, file := filepath.Split(path)
 	ext := filepath.Ext(file)
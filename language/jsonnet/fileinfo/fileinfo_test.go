@@ -14,20 +14,26 @@ import (
 
 func TestNewFilePath(t *testing.T) {
 	testCases := []struct {
-		root string
-		elem []string
-		want fileinfo.FilePath
+		root    string
+		elem    []string
+		want    fileinfo.FilePath
+		wantErr bool
 	}{
-		{"/a", []string{"b/c", "d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}},
-		{"/a", []string{"b/c/d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}},
-		{"/a", []string{"/a/b/c/d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}},
-		// This is a valid case for the method, but we should not allow imports outside the root code base
-		{"/a", []string{"/b/c/d.txt"}, fileinfo.FilePath{Root: "/a", Package: "../b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "../b/c/d.txt"}},
+		{"/a", []string{"b/c", "d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}, false},
+		{"/a", []string{"b/c/d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}, false},
+		{"/a", []string{"/a/b/c/d.txt"}, fileinfo.FilePath{Root: "/a", Package: "b/c", Ext: ".txt", Filename: "d.txt", Name: "d", Path: "b/c/d.txt"}, false},
+		// Paths outside the root code base are refused
+		{"/a", []string{"/b/c/d.txt"}, fileinfo.FilePath{}, true},
+		{"/a", []string{"/a2/c/d.txt"}, fileinfo.FilePath{}, true},
+		{"/a", []string{"../b/c/d.txt"}, fileinfo.FilePath{}, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(filepath.Join(tc.elem...), func(t *testing.T) {
-			got, _ := fileinfo.NewFilePath(tc.root, tc.elem...)
+			got, err := fileinfo.NewFilePath(tc.root, tc.elem...)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v; want error %t", err, tc.wantErr)
+			}
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("got: %#v; want: %#v", got, tc.want)
 			}
@@ -117,3 +123,21 @@ func TestNewDataLabel(t *testing.T) {
 		})
 	}
 }
+
+func TestPositionString(t *testing.T) {
+	testCases := []struct {
+		pos  fileinfo.Position
+		want string
+	}{
+		{fileinfo.Position{Filename: "a/b/foo.jsonnet", Line: 1, Column: 1}, "a/b/foo.jsonnet:1:1"},
+		{fileinfo.Position{Filename: "foo.jsonnet", Line: 12, Column: 34}, "foo.jsonnet:12:34"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := tc.pos.String(); got != tc.want {
+				t.Errorf("got: %q; want: %q", got, tc.want)
+			}
+		})
+	}
+}
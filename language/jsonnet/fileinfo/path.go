@@ -0,0 +1,123 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package fileinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootError reports a path out of the root of the workspace, either by itself
+// or through a symlink.
+type RootError struct {
+	Root   string // Absolute path to the root of the workspace
+	Path   string // Path being resolved
+	Link   string // Symlink leading out of the root, if any
+	Target string // Target of the symlink
+}
+
+func (e *RootError) Error() string {
+	if e.Link != "" {
+		return fmt.Sprintf("%s: symlink %s points to %s, which is out of the root of the workspace %s", e.Path, e.Link, e.Target, e.Root)
+	}
+	return fmt.Sprintf("%s is out of the root of the workspace %s", e.Path, e.Root)
+}
+
+// Rel returns the path of an absolute path relative to root, and whether it is
+// within root. Unlike a string prefix, it compares whole path components, so
+// /work/root2/x is not within /work/root.
+func Rel(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// Canonical returns the canonical form of an absolute path within root: the
+// symlinked directories it goes through are replaced by their targets, so the
+// file belongs to the package it actually is in. The file itself is kept, even
+// if it is a symlink, as it is a source file of its package.
+//
+// Components that do not exist are kept as they are, as imported files may be
+// generated or missing. Canonical fails with a *RootError if the path, or any
+// symlink it goes through, is out of root.
+func Canonical(root, path string) (string, error) {
+	path = filepath.Clean(path)
+	rel, ok := Rel(root, path)
+	if !ok {
+		return "", &RootError{Root: root, Path: path}
+	}
+	if rel == "." {
+		return root, nil
+	}
+
+	// Symlink targets are compared with the real root, as the root itself may
+	// be reached through a symlink.
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+
+	elems := strings.Split(rel, string(filepath.Separator))
+	cur := root
+	for i, elem := range elems {
+		next := filepath.Join(cur, elem)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			// Not found: keep the rest of the path as is
+			return filepath.Join(append([]string{cur}, elems[i:]...)...), nil
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(cur, target)
+		}
+		real, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			// Dangling symlink
+			real = filepath.Clean(target)
+		}
+		targetRel, ok := Rel(realRoot, real)
+		if !ok {
+			return "", &RootError{Root: root, Path: path, Link: next, Target: target}
+		}
+		if i == len(elems)-1 {
+			// Keep the file itself
+			cur = next
+			break
+		}
+		cur = filepath.Join(root, targetRel)
+	}
+	return cur, nil
+}
+
+// RepoBoundaryFiles are the files marking the root of a repository
+var RepoBoundaryFiles = []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"}
+
+// RepoBoundary returns the closest directory containing path, below root, which is
+// the root of a nested repository, e.g. a local_repository checked into the tree.
+// The directory is returned relative to root.
+func RepoBoundary(root, path string) (string, bool) {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		rel, ok := Rel(root, dir)
+		if !ok || rel == "." {
+			return "", false
+		}
+		for _, name := range RepoBoundaryFiles {
+			if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() {
+				return filepath.ToSlash(rel), true
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package fileinfo_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+func TestRel(t *testing.T) {
+	testCases := []struct {
+		root, path string
+		want       string
+		wantOk     bool
+	}{
+		{"/work/root", "/work/root", ".", true},
+		{"/work/root", "/work/root/a/b", "a/b", true},
+		{"/work/root", "/work/root2/a", "", false},
+		{"/work/root", "/work/root/../root2/a", "", false},
+		{"/work/root", "/work", "", false},
+		{"/work/root", "/work/root/..a/b", "..a/b", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, ok := fileinfo.Rel(tc.root, tc.path)
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("got %q, %t; want %q, %t", got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "fileinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{"lib/real", "app", "root2"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"app/lib":      "../lib/real",
+		"abs":          filepath.Join(root, "lib"),
+		"escape":       "../outside",
+		"escapeabs":    outside,
+		"app/file.txt": "../lib/real/file.txt",
+		"app/out.txt":  "../../outside/file.txt",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		path     string
+		want     string
+		wantLink string
+		wantErr  bool
+	}{
+		{"app/main.jsonnet", "app/main.jsonnet", "", false},
+		{"app/lib/a.libsonnet", "lib/real/a.libsonnet", "", false},
+		{"app/lib/../x.libsonnet", "app/x.libsonnet", "", false},
+		{"abs/real/a.libsonnet", "lib/real/a.libsonnet", "", false},
+		{"app/file.txt", "app/file.txt", "", false},
+		{"missing/a/b.libsonnet", "missing/a/b.libsonnet", "", false},
+		{"root2/a.libsonnet", "root2/a.libsonnet", "", false},
+		{"../outside/a.libsonnet", "", "", true},
+		{"../root2/a.libsonnet", "", "", true},
+		{"escape/a.libsonnet", "", "escape", true},
+		{"escapeabs/a.libsonnet", "", "escapeabs", true},
+		{"app/out.txt", "", "app/out.txt", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := fileinfo.Canonical(root, filepath.Join(root, tc.path))
+			if tc.wantErr {
+				var rerr *fileinfo.RootError
+				if !errors.As(err, &rerr) {
+					t.Fatalf("got error %v; want a *RootError", err)
+				}
+				if want := filepath.Join(root, tc.wantLink); tc.wantLink != "" && rerr.Link != want {
+					t.Errorf("got link %q; want %q", rerr.Link, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(root, tc.want); got != want {
+				t.Errorf("got %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRepoBoundary(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"WORKSPACE", "nested/WORKSPACE", "nested/inner/MODULE.bazel", "plain/BUILD.bazel"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory named like a boundary file is not one
+	if err := os.MkdirAll(filepath.Join(root, "dir/WORKSPACE"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		path   string
+		want   string
+		wantOk bool
+	}{
+		{"a.libsonnet", "", false},
+		{"plain/a.libsonnet", "", false},
+		{"dir/a.libsonnet", "", false},
+		{"nested/a.libsonnet", "nested", true},
+		{"nested/lib/a.libsonnet", "nested", true},
+		{"nested/inner/lib/a.libsonnet", "nested/inner", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, ok := fileinfo.RepoBoundary(root, filepath.Join(root, tc.path))
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("got %q, %t; want %q, %t", got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
@@ -4,16 +4,22 @@
 package jsonnet_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
 	gojsonnet "github.com/google/go-jsonnet"
 )
@@ -36,6 +42,7 @@ func normalizeFileInfo(info *fileinfo.FileInfo) {
 func TestJsonnetFileInfo(t *testing.T) {
 	testCases := []struct {
 		desc, dir, rel, name, content string
+		others                        []string // Other files in the same package
 		want                          *fileinfo.FileInfo
 	}{
 		{
@@ -44,9 +51,16 @@ func TestJsonnetFileInfo(t *testing.T) {
 			name:    "bar.jsonnet",
 			content: "{}",
 			want: &fileinfo.FileInfo{
-				Path:        fileinfo.FilePath{Package: "pkg/foo", Ext: ".jsonnet", Filename: "bar.jsonnet", Name: "bar", Path: "pkg/foo/bar.jsonnet"},
-				Imports:     map[string]fileinfo.FilePath{},
-				DataImports: map[string]fileinfo.FilePath{},
+				Path:         fileinfo.FilePath{Package: "pkg/foo", Ext: ".jsonnet", Filename: "bar.jsonnet", Name: "bar", Path: "pkg/foo/bar.jsonnet"},
+				Imports:      map[string]fileinfo.FilePath{},
+				DataImports:  map[string]fileinfo.FilePath{},
+				Positions:    map[string]fileinfo.Position{},
+				Kinds:        map[string]fileinfo.ImportKind{},
+				ImportStrs:   map[string]string{},
+				Missing:      map[string]bool{},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "different quotes imports",
@@ -60,6 +74,25 @@ func TestJsonnetFileInfo(t *testing.T) {
 					"pkg/foo/doublequotes.jsonnet": {Package: "pkg/foo", Ext: ".jsonnet", Filename: "doublequotes.jsonnet", Name: "doublequotes", Path: "pkg/foo/doublequotes.jsonnet"},
 				},
 				DataImports: map[string]fileinfo.FilePath{},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/singlequotes.jsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 2},
+					"pkg/foo/doublequotes.jsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 36},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/singlequotes.jsonnet": fileinfo.Import,
+					"pkg/foo/doublequotes.jsonnet": fileinfo.Import,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/singlequotes.jsonnet": "singlequotes.jsonnet",
+					"pkg/foo/doublequotes.jsonnet": "doublequotes.jsonnet",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/singlequotes.jsonnet": true,
+					"pkg/foo/doublequotes.jsonnet": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "libsonnet import",
@@ -72,6 +105,21 @@ func TestJsonnetFileInfo(t *testing.T) {
 					"pkg/foo/demo.libsonnet": {Package: "pkg/foo", Ext: ".libsonnet", Filename: "demo.libsonnet", Name: "demo", Path: "pkg/foo/demo.libsonnet"},
 				},
 				DataImports: map[string]fileinfo.FilePath{},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/demo.libsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 1},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/demo.libsonnet": fileinfo.Import,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/demo.libsonnet": "demo.libsonnet",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/demo.libsonnet": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "different folder imports",
@@ -85,6 +133,25 @@ func TestJsonnetFileInfo(t *testing.T) {
 					"root.jsonnet":      {Package: "", Ext: ".jsonnet", Filename: "root.jsonnet", Name: "root", Path: "root.jsonnet"},
 				},
 				DataImports: map[string]fileinfo.FilePath{},
+				Positions: map[string]fileinfo.Position{
+					"pkg/pkg.libsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 2},
+					"root.jsonnet":      {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 32},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/pkg.libsonnet": fileinfo.Import,
+					"root.jsonnet":      fileinfo.Import,
+				},
+				ImportStrs: map[string]string{
+					"pkg/pkg.libsonnet": "../pkg.libsonnet",
+					"root.jsonnet":      "../../root.jsonnet",
+				},
+				Missing: map[string]bool{
+					"pkg/pkg.libsonnet": true,
+					"root.jsonnet":      true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "data import",
@@ -97,6 +164,21 @@ func TestJsonnetFileInfo(t *testing.T) {
 				DataImports: map[string]fileinfo.FilePath{
 					"pkg/foo/data/db.json": {Package: "pkg/foo/data", Ext: ".json", Filename: "db.json", Name: "db", Path: "pkg/foo/data/db.json"},
 				},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/data/db.json": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 1},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/data/db.json": fileinfo.ImportStr,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/data/db.json": "data/db.json",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/data/db.json": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "mixed data and jsonnet imports",
@@ -111,6 +193,25 @@ func TestJsonnetFileInfo(t *testing.T) {
 				DataImports: map[string]fileinfo.FilePath{
 					"pkg/foo/data/db.json": {Package: "pkg/foo/data", Ext: ".json", Filename: "db.json", Name: "db", Path: "pkg/foo/data/db.json"},
 				},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/demo.libsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 2},
+					"pkg/foo/data/db.json":   {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 33},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/demo.libsonnet": fileinfo.Import,
+					"pkg/foo/data/db.json":   fileinfo.ImportStr,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/demo.libsonnet": "demo.libsonnet",
+					"pkg/foo/data/db.json":   "data/db.json",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/demo.libsonnet": true,
+					"pkg/foo/data/db.json":   true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "json-like import",
@@ -123,6 +224,21 @@ func TestJsonnetFileInfo(t *testing.T) {
 				DataImports: map[string]fileinfo.FilePath{
 					"pkg/foo/data/db.json": {Package: "pkg/foo/data", Ext: ".json", Filename: "db.json", Name: "db", Path: "pkg/foo/data/db.json"},
 				},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/data/db.json": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 1},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/data/db.json": fileinfo.Import,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/data/db.json": "data/db.json",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/data/db.json": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		}, {
 			desc:    "commented import",
@@ -135,6 +251,74 @@ func TestJsonnetFileInfo(t *testing.T) {
 				DataImports: map[string]fileinfo.FilePath{
 					"pkg/foo/data/db.json": {Package: "pkg/foo/data", Ext: ".json", Filename: "db.json", Name: "db", Path: "pkg/foo/data/db.json"},
 				},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/data/db.json": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 2},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/data/db.json": fileinfo.Import,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/data/db.json": "data/db.json",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/data/db.json": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
+			},
+		}, {
+			desc:    "external variables",
+			rel:     "pkg/foo",
+			name:    "bar.jsonnet",
+			content: "{\n  env: std.extVar('env'),\n  region: std.extVar('region'),\n  again: std.extVar('env'),\n}",
+			want: &fileinfo.FileInfo{
+				Path:         fileinfo.FilePath{Package: "pkg/foo", Ext: ".jsonnet", Filename: "bar.jsonnet", Name: "bar", Path: "pkg/foo/bar.jsonnet"},
+				Imports:      map[string]fileinfo.FilePath{},
+				DataImports:  map[string]fileinfo.FilePath{},
+				Positions:    map[string]fileinfo.Position{},
+				Kinds:        map[string]fileinfo.ImportKind{},
+				ImportStrs:   map[string]string{},
+				Missing:      map[string]bool{},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars: map[string]fileinfo.Position{
+					"env":    {Filename: "pkg/foo/bar.jsonnet", Line: 2, Column: 8},
+					"region": {Filename: "pkg/foo/bar.jsonnet", Line: 3, Column: 11},
+				},
+			},
+		}, {
+			desc:    "existing imports",
+			rel:     "pkg/foo",
+			name:    "bar.jsonnet",
+			content: "(import 'demo.libsonnet') { db: importstr 'db.json' }",
+			others:  []string{"demo.libsonnet"},
+			want: &fileinfo.FileInfo{
+				Path: fileinfo.FilePath{Package: "pkg/foo", Ext: ".jsonnet", Filename: "bar.jsonnet", Name: "bar", Path: "pkg/foo/bar.jsonnet"},
+				Imports: map[string]fileinfo.FilePath{
+					"pkg/foo/demo.libsonnet": {Package: "pkg/foo", Ext: ".libsonnet", Filename: "demo.libsonnet", Name: "demo", Path: "pkg/foo/demo.libsonnet"},
+				},
+				DataImports: map[string]fileinfo.FilePath{
+					"pkg/foo/db.json": {Package: "pkg/foo", Ext: ".json", Filename: "db.json", Name: "db", Path: "pkg/foo/db.json"},
+				},
+				Positions: map[string]fileinfo.Position{
+					"pkg/foo/demo.libsonnet": {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 2},
+					"pkg/foo/db.json":        {Filename: "pkg/foo/bar.jsonnet", Line: 1, Column: 33},
+				},
+				Kinds: map[string]fileinfo.ImportKind{
+					"pkg/foo/demo.libsonnet": fileinfo.Import,
+					"pkg/foo/db.json":        fileinfo.ImportStr,
+				},
+				ImportStrs: map[string]string{
+					"pkg/foo/demo.libsonnet": "demo.libsonnet",
+					"pkg/foo/db.json":        "db.json",
+				},
+				Missing: map[string]bool{
+					"pkg/foo/db.json": true,
+				},
+				External:     map[string]label.Label{},
+				ExternalData: map[string]label.Label{},
+				ExtVars:      map[string]fileinfo.Position{},
 			},
 		},
 	}
@@ -155,6 +339,11 @@ func TestJsonnetFileInfo(t *testing.T) {
 			if err := ioutil.WriteFile(filepath.Join(dir, tc.name), []byte(tc.content), 0600); err != nil {
 				t.Fatal(err)
 			}
+			for _, other := range tc.others {
+				if err := ioutil.WriteFile(filepath.Join(dir, other), []byte("{}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
 
 			got, err := jsonnet.NewFileInfo(&config.Config{}, dir, tc.rel, tc.name, importer)
 			if err != nil {
@@ -168,6 +357,194 @@ func TestJsonnetFileInfo(t *testing.T) {
 	}
 }
 
+func TestJsonnetFileInfoVendored(t *testing.T) {
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	module := "vendor/github.com/grafana/grafonnet-lib/grafonnet"
+	for _, file := range []string{"app/lib.libsonnet", module + "/grafana.libsonnet"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(file)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, file), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := `(import 'github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet') +
+(import 'grafonnet/grafana.libsonnet') +
+(import 'lib.libsonnet')`
+	if err := ioutil.WriteFile(filepath.Join(root, "app/main.jsonnet"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config.Config{Exts: map[string]interface{}{}}
+	conf := jsonnet.GetConfig(c)
+	conf.Bundle = &bundler.Bundle{
+		Vendor:  "vendor",
+		Modules: []bundler.Module{{Name: "grafonnet", ImportPath: "github.com/grafana/grafonnet-lib/grafonnet", Dir: module}},
+	}
+	c.Exts["jsonnet"] = conf
+
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	got, err := jsonnet.NewFileInfo(c, filepath.Join(root, "app"), "app", "main.jsonnet", importer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeFileInfo(got)
+	want := map[string]fileinfo.FilePath{
+		module + "/grafana.libsonnet": {Package: module, Ext: ".libsonnet", Filename: "grafana.libsonnet", Name: "grafana", Path: module + "/grafana.libsonnet"},
+		"app/lib.libsonnet":           {Package: "app", Ext: ".libsonnet", Filename: "lib.libsonnet", Name: "lib", Path: "app/lib.libsonnet"},
+	}
+	if !reflect.DeepEqual(got.Imports, want) {
+		t.Errorf("got %#v; want %#v", got.Imports, want)
+	}
+	if len(got.Missing) > 0 {
+		t.Errorf("got missing imports %v; want none", got.Missing)
+	}
+}
+
+func TestJsonnetFileInfoExternal(t *testing.T) {
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	content := `(import 'ksonnet-lib/ksonnet.beta.4/k.libsonnet') +
+(import '../../shared/lib/utils.libsonnet') +
+(import 'grafonnet/grafana.libsonnet') +
+{ data: importstr 'ksonnet-lib/data/swagger.json', l: import 'local.libsonnet' }`
+	if err := os.MkdirAll(filepath.Join(root, "app"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"app/main.jsonnet": content, "app/local.libsonnet": "{}"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := rule.LoadData(filepath.Join(root, "BUILD.bazel"), "", []byte(`
+# gazelle:jsonnet_external_repo ksonnet-lib @ksonnet//
+# gazelle:jsonnet_external_repo ../shared @shared//jsonnet
+# gazelle:jsonnet_external_repo grafonnet @grafonnet//:grafonnet_library
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// grafonnet is not declared in the WORKSPACE file, but may be by a macro
+	c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+	c.Repos = []*rule.Rule{rule.NewRule("http_archive", "ksonnet"), rule.NewRule("http_archive", "shared")}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	jsonnet.NewLanguage().Configure(c, "", f)
+	if want := "repository @grafonnet is not declared"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs: got %q; want %q", logs.String(), want)
+	}
+	if strings.Count(logs.String(), "\n") != 1 {
+		t.Errorf("logs: got %q; want a single line", logs.String())
+	}
+
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	got, err := jsonnet.NewFileInfo(c, filepath.Join(root, "app"), "app", "main.jsonnet", importer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantExternal := map[string]label.Label{
+		"ksonnet-lib/ksonnet.beta.4/k.libsonnet": label.New("ksonnet", "ksonnet.beta.4", "k_library"),
+		"../../shared/lib/utils.libsonnet":       label.New("shared", "jsonnet/lib", "utils_library"),
+		"grafonnet/grafana.libsonnet":            label.New("grafonnet", "", "grafonnet_library"),
+	}
+	if !reflect.DeepEqual(got.External, wantExternal) {
+		t.Errorf("got %v; want %v", got.External, wantExternal)
+	}
+	wantData := map[string]label.Label{
+		"ksonnet-lib/data/swagger.json": label.New("ksonnet", "data", "swagger.json"),
+	}
+	if !reflect.DeepEqual(got.ExternalData, wantData) {
+		t.Errorf("got %v; want %v", got.ExternalData, wantData)
+	}
+	if _, found := got.Imports["app/local.libsonnet"]; !found || len(got.Imports) != 1 {
+		t.Errorf("got imports %v; want app/local.libsonnet only", got.Imports)
+	}
+	if pos := got.Positions["grafonnet/grafana.libsonnet"]; pos.Line != 3 {
+		t.Errorf("got position %v; want line 3", pos)
+	}
+}
+
+func TestJsonnetFileInfoNested(t *testing.T) {
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	content := `(import '../third_party/mixins/lib/alerts.libsonnet') +
+(import '../tools/WORKSPACE.libsonnet') +
+(import '../vendored/k.libsonnet') +
+{ data: importstr '../third_party/mixins/dashboards/up.json', l: import 'local.libsonnet' }`
+	files := map[string]string{
+		"app/main.jsonnet":                        content,
+		"app/local.libsonnet":                     "{}",
+		"third_party/mixins/WORKSPACE":            "",
+		"third_party/mixins/lib/alerts.libsonnet": "{}",
+		"third_party/mixins/dashboards/up.json":   "{}",
+		"tools/MODULE.bazel":                      "",
+		"tools/WORKSPACE.libsonnet":               "{}",
+		"vendored/WORKSPACE.bazel":                "",
+		"vendored/k.libsonnet":                    "{}",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := rule.LoadData(filepath.Join(root, "BUILD.bazel"), "", []byte(`
+# gazelle:jsonnet_local_repo tools @tools
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixins := rule.NewRule("local_repository", "mixins")
+	mixins.SetAttr("path", "third_party/mixins")
+	c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}, Repos: []*rule.Rule{mixins}}
+	jsonnet.NewLanguage().Configure(c, "", f)
+
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	got, err := jsonnet.NewFileInfo(c, filepath.Join(root, "app"), "app", "main.jsonnet", importer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantExternal := map[string]label.Label{
+		"../third_party/mixins/lib/alerts.libsonnet": label.New("mixins", "lib", "alerts_library"),
+		"../tools/WORKSPACE.libsonnet":               label.New("tools", "", "workspace_library"),
+	}
+	if !reflect.DeepEqual(got.External, wantExternal) {
+		t.Errorf("got %v; want %v", got.External, wantExternal)
+	}
+	wantData := map[string]label.Label{
+		"../third_party/mixins/dashboards/up.json": label.New("mixins", "dashboards", "up.json"),
+	}
+	if !reflect.DeepEqual(got.ExternalData, wantData) {
+		t.Errorf("got %v; want %v", got.ExternalData, wantData)
+	}
+	// Nested repositories without name are labeled as packages of the workspace
+	for _, want := range []string{"app/local.libsonnet", "vendored/k.libsonnet"} {
+		if _, found := got.Imports[want]; !found || len(got.Imports) != 2 {
+			t.Errorf("got imports %v; want app/local.libsonnet and vendored/k.libsonnet", got.Imports)
+		}
+	}
+}
+
 func TestNormalizeImport(t *testing.T) {
 	path := fileinfo.FilePath{Root: "/root", Package: "ws"}
 	testCases := []struct {
@@ -182,6 +559,8 @@ func TestNormalizeImport(t *testing.T) {
 		// wrong cases
 		{"/var/a.jsonnet", "", jsonnet.OutOfWorkspaceError("")},
 		{"../../a.jsonnet", "", jsonnet.OutOfWorkspaceError("")},
+		{"/root2/a.jsonnet", "", jsonnet.OutOfWorkspaceError("")},
+		{"../../root2/a.jsonnet", "", jsonnet.OutOfWorkspaceError("")},
 	}
 
 	for _, tc := range testCases {
@@ -202,3 +581,80 @@ func TestNormalizeImport(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeImportSymlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "jsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "root")
+	for _, dir := range []string{"ws", "shared/lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../shared/lib", filepath.Join(root, "ws", "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(tmp, filepath.Join(root, "ws", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := fileinfo.FilePath{Root: root, Package: "ws"}
+	got, err := jsonnet.NormalizeImport(path, "lib/utils.libsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "shared/lib/utils.libsonnet"); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	_, err = jsonnet.NormalizeImport(path, "up/other/a.libsonnet")
+	var rerr *fileinfo.RootError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v; want a *fileinfo.RootError", err)
+	}
+	if want := filepath.Join(root, "ws", "up"); rerr.Link != want {
+		t.Errorf("got link %q; want %q", rerr.Link, want)
+	}
+}
+
+func TestNormalizeImportAlias(t *testing.T) {
+	path := fileinfo.FilePath{Root: "/root", Package: "ws"}
+	aliases := []jsonnet.ImportAlias{
+		{Prefix: "lib", Dir: "jsonnet/shared/lib"},
+		{Prefix: "lib/k8s", Dir: "third_party/k8s"},
+		{Prefix: "mixins", Dir: "monitoring"},
+	}
+	testCases := []struct {
+		importstr, want, root string
+		hasRoot               bool
+	}{
+		{"lib/utils.libsonnet", "/root/jsonnet/shared/lib/utils.libsonnet", "jsonnet/shared", true},
+		{"lib/k8s/deployment.libsonnet", "/root/third_party/k8s/deployment.libsonnet", "", false},
+		{"mixins/alerts.libsonnet", "/root/monitoring/alerts.libsonnet", "", false},
+		{"libs/utils.libsonnet", "/root/ws/libs/utils.libsonnet", "", false},
+		{"./lib/utils.libsonnet", "/root/ws/lib/utils.libsonnet", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.importstr, func(t *testing.T) {
+			got, err := jsonnet.NormalizeImport(path, tc.importstr, aliases...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want != got {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+			a, _, found := jsonnet.MatchImportAlias(aliases, tc.importstr)
+			if !found {
+				return
+			}
+			if root, ok := a.Root(); root != tc.root || ok != tc.hasRoot {
+				t.Errorf("root: got %q, %t; want %q, %t", root, ok, tc.root, tc.hasRoot)
+			}
+		})
+	}
+}
@@ -4,8 +4,146 @@
 package jsonnet
 
 import (
+	"path"
+	"regexp"
+	"strings"
+
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	// wrapperLoad is the suffix of the def.bzl file of this repository, which
+	// used to provide jsonnet_library as a filegroup wrapper.
+	wrapperLoad = "//:def.bzl"
 )
 
-func (*Lang) Fix(c *config.Config, f *rule.File) {}
+var (
+	// duplicateRe matches the _N suffix of the names of duplicated rules
+	duplicateRe = regexp.MustCompile(`_\d+$`)
+)
+
+// Fix implements language.Language. It migrates the build files written for
+// former versions of the language, and only runs with the fix command:
+//
+//   - loads of jsonnet_library from the filegroup wrapper of def.bzl, and loads
+//     of io_bazel_rules_jsonnet when the rules are provided by another repository,
+//     are rewritten to load the configured repository.
+//   - jsonnet_to_json rules duplicated with a _N suffix, and the same src and outs,
+//     are removed.
+func (*Lang) Fix(c *config.Config, f *rule.File) {
+	if !c.ShouldFix || f == nil {
+		return
+	}
+	conf := GetConfig(c)
+	fixLoads(conf, f)
+	removeDuplicateRules(conf, f)
+}
+
+// fixLoads rewrites the loads of the jsonnet rules from former locations
+func fixLoads(conf *Config, f *rule.File) {
+	repo := conf.RulesRepo
+	if repo == "" {
+		repo = defaultRulesRepo
+	}
+	want := rulesLoad(repo)
+
+	var current *rule.Load
+	var legacy []*rule.Load
+	for _, l := range f.Loads {
+		switch {
+		case l.Name() == want:
+			current = l
+		case strings.HasSuffix(l.Name(), wrapperLoad) && l.Has(libraryRule),
+			l.Name() == rulesLoad(defaultRulesRepo):
+			legacy = append(legacy, l)
+		}
+	}
+
+	for _, l := range legacy {
+		// The wrapper only provides jsonnet_library, while the rules may
+		// provide other symbols.
+		symbols := l.Symbols()
+		if l.Name() != rulesLoad(defaultRulesRepo) {
+			symbols = []string{libraryRule}
+		}
+		if current == nil {
+			current = rule.NewLoad(want)
+			current.Insert(f, l.Index())
+		}
+		for _, sym := range symbols {
+			current.Add(sym)
+			l.Remove(sym)
+		}
+		if l.IsEmpty() {
+			l.Delete()
+		}
+	}
+	f.Sync()
+}
+
+// removeDuplicateRules removes the jsonnet_to_json rules duplicating the rule
+// of their file: named with a _N suffix, with the same src and outs. The rules
+// of the configured variants, e.g. main_1_to_json for the variant 1, are kept.
+func removeDuplicateRules(conf *Config, f *rule.File) {
+	rules := map[string]*rule.Rule{}
+	for _, r := range f.Rules {
+		if r.Kind() == toJSONRule {
+			rules[r.Name()] = r
+		}
+	}
+
+	for _, r := range f.Rules {
+		src := r.AttrString("src")
+		if r.Kind() != toJSONRule || src == "" || r.ShouldKeep() {
+			continue
+		}
+		fpath := fileinfo.FilePath{Package: f.Pkg, Filename: src, Name: strings.TrimSuffix(path.Base(src), path.Ext(src))}
+		current, found := rules[fpath.RuleName(toJSONRulePrefix)]
+		if !found || r == current || isVariantRule(conf, r, fpath) {
+			continue
+		}
+		if isDuplicate(r, current) {
+			r.Delete()
+		}
+	}
+	f.Sync()
+}
+
+// isDuplicate returns whether a rule is a duplicate of the given rule, i.e. its
+// name has a _N suffix and it has the same src and outs.
+func isDuplicate(r, current *rule.Rule) bool {
+	base := strings.TrimSuffix(current.Name(), "_"+toJSONRulePrefix)
+	name := strings.TrimSuffix(r.Name(), "_"+toJSONRulePrefix)
+	if !strings.HasPrefix(name, base) || !duplicateRe.MatchString(name) || duplicateRe.FindString(name) != name[len(base):] {
+		return false
+	}
+	if r.AttrString("src") != current.AttrString("src") {
+		return false
+	}
+	outs, currentOuts := r.AttrStrings("outs"), current.AttrStrings("outs")
+	if len(outs) != len(currentOuts) {
+		return false
+	}
+	for i := range outs {
+		if outs[i] != currentOuts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isVariantRule returns whether a rule is named after a configured variant of
+// its file, see variantRuleName.
+func isVariantRule(conf *Config, r *rule.Rule, fpath fileinfo.FilePath) bool {
+	if conf.Variants == nil {
+		return false
+	}
+	for _, variant := range conf.Variants.Values {
+		if r.Name() == variantRuleName(fpath, variant) {
+			return true
+		}
+	}
+	return false
+}
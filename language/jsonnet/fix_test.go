@@ -0,0 +1,189 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestFix(t *testing.T) {
+	testCases := []struct {
+		desc, rulesRepo, old, want string
+		update                     bool
+	}{
+		{
+			desc: "wrapper load",
+			old: `load("@jsonnet_gazelle//:def.bzl", "jsonnet_library")
+
+jsonnet_library(
+    name = "foo_library",
+    srcs = ["foo.jsonnet"],
+    visibility = ["//visibility:public"],
+)
+`,
+			want: `load("@io_bazel_rules_jsonnet//jsonnet:jsonnet.bzl", "jsonnet_library")
+
+jsonnet_library(
+    name = "foo_library",
+    srcs = ["foo.jsonnet"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			desc:      "rules_jsonnet load",
+			rulesRepo: "rules_jsonnet",
+			old: `load("@io_bazel_rules_jsonnet//jsonnet:jsonnet.bzl", "jsonnet_library", "jsonnet_to_json_test")
+load("@jsonnet_gazelle//:def.bzl", "jsonnet_library")
+`,
+			want: `load("@rules_jsonnet//jsonnet:jsonnet.bzl", "jsonnet_library", "jsonnet_to_json_test")
+`,
+		}, {
+			desc: "duplicates",
+			old: `jsonnet_to_json(
+    name = "foo_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo.json"],
+)
+
+jsonnet_to_json(
+    name = "foo_1_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo.json"],
+)
+
+jsonnet_to_json(
+    name = "foo_2_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo_2.json"],
+)
+
+jsonnet_to_json(
+    name = "foo_prod_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo.prod.json"],
+)
+
+jsonnet_to_json(
+    name = "bar_1_to_json",
+    src = "bar.jsonnet",
+    outs = ["bar_1.json"],
+)
+`,
+			want: `jsonnet_to_json(
+    name = "foo_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo.json"],
+)
+
+jsonnet_to_json(
+    name = "foo_2_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo_2.json"],
+)
+
+jsonnet_to_json(
+    name = "foo_prod_to_json",
+    src = "foo.jsonnet",
+    outs = ["foo.prod.json"],
+)
+
+jsonnet_to_json(
+    name = "bar_1_to_json",
+    src = "bar.jsonnet",
+    outs = ["bar_1.json"],
+)
+`,
+		}, {
+			desc: "numeric variants",
+			old: `# gazelle:jsonnet_variants shard=1,2
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json(
+    name = "main_1_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json(
+    name = "main_2_to_json",
+    src = "main.jsonnet",
+    outs = ["main.2.json"],
+)
+`,
+			want: `# gazelle:jsonnet_variants shard=1,2
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json(
+    name = "main_1_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json(
+    name = "main_2_to_json",
+    src = "main.jsonnet",
+    outs = ["main.2.json"],
+)
+`,
+		}, {
+			desc:   "update",
+			update: true,
+			old: `load("@jsonnet_gazelle//:def.bzl", "jsonnet_library")
+
+jsonnet_library(
+    name = "foo",
+    srcs = ["foo.jsonnet"],
+)
+`,
+			want: `load("@jsonnet_gazelle//:def.bzl", "jsonnet_library")
+
+jsonnet_library(
+    name = "foo",
+    srcs = ["foo.jsonnet"],
+)
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := rule.LoadData("pkg/BUILD.bazel", "pkg", []byte(tc.old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := ""
+			if tc.rulesRepo != "" {
+				root = "# gazelle:jsonnet_rules_repo " + tc.rulesRepo
+			}
+			rf, err := rule.LoadData("BUILD.bazel", "", []byte(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &config.Config{RepoRoot: t.Name(), Exts: map[string]interface{}{}, ShouldFix: !tc.update}
+			lang := jsonnet.NewLanguage()
+			lang.Configure(c, "", rf)
+			lang.Configure(c, "pkg", f)
+
+			lang.Fix(c, f)
+			if got := string(f.Format()); strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
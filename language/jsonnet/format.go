@@ -0,0 +1,135 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	formatTestRuleName = "jsonnet_format_test"
+	// defaultFormatter is jsonnetfmt of the go-jsonnet repository of rules_jsonnet
+	defaultFormatter = "@jsonnet_go//cmd/jsonnetfmt"
+)
+
+var (
+	// formatOptions are the options of jsonnetfmt set with the jsonnet_format_options
+	// directive, in the order they are passed, and the values they accept, if any.
+	// Boolean options accept true or false.
+	formatOptions = []struct {
+		name   string
+		values []string
+	}{
+		{"indent", nil},
+		{"max_blank_lines", nil},
+		{"string_style", []string{"d", "s", "l"}},
+		{"comment_style", []string{"h", "s", "l"}},
+		{"pad_arrays", []string{"true", "false"}},
+		{"pad_objects", []string{"true", "false"}},
+		{"pretty_field_names", []string{"true", "false"}},
+		{"sort_imports", []string{"true", "false"}},
+	}
+)
+
+// setFormatter sets the jsonnetfmt binary run by the format tests from a
+// jsonnet_formatter value, its label.
+func (conf *Config) setFormatter(value string) error {
+	if _, err := label.Parse(value); err != nil {
+		return fmt.Errorf("want the label of jsonnetfmt: %v", err)
+	}
+	conf.Formatter = value
+	return nil
+}
+
+// addFormatOptions adds options of jsonnetfmt from a jsonnet_format_options
+// value, e.g. "indent=2 string_style=s comment_style=s".
+func (conf *Config) addFormatOptions(value string) error {
+	options := map[string]string{}
+	for _, field := range strings.Fields(value) {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return fmt.Errorf("got %q: want <option>=<value> ...", field)
+		}
+		name, val := field[:i], field[i+1:]
+		known := false
+		for _, o := range formatOptions {
+			if o.name != name {
+				continue
+			}
+			known = true
+			if o.values == nil {
+				if n, err := strconv.Atoi(val); err != nil || n < 0 {
+					return fmt.Errorf("%s: got %q: want a number", name, val)
+				}
+			} else if !hasValue(o.values, val) {
+				return fmt.Errorf("%s: got %q: must be one of %s", name, val, strings.Join(o.values, ", "))
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		options[name] = val
+	}
+	// Options can be set again in a subdirectory
+	for name, val := range options {
+		conf.FormatOptions[name] = val
+	}
+	return nil
+}
+
+// hasValue returns whether a list of values contains a value
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatArgs returns the arguments of jsonnetfmt testing the formatting of files:
+// the options, as flags, and the files.
+func (conf *Config) formatArgs(files []string) []string {
+	args := []string{"--test"}
+	for _, o := range formatOptions {
+		val, found := conf.FormatOptions[o.name]
+		if !found {
+			continue
+		}
+		flag := "--" + strings.Replace(o.name, "_", "-", -1)
+		switch val {
+		case "true":
+			args = append(args, flag)
+		case "false":
+			args = append(args, "--no-"+strings.TrimPrefix(flag, "--"))
+		default:
+			args = append(args, flag, val)
+		}
+	}
+	for _, f := range files {
+		args = append(args, fmt.Sprintf("$(location %s)", f))
+	}
+	return args
+}
+
+// newFormatTestRule returns a jsonnet_format_test, the sh_test of def.bzl, running
+// jsonnetfmt on the jsonnet files of a package, which fails if any of them is not
+// formatted with the options of the jsonnet_format_options directive.
+//
+// Its args are ordered, so Gazelle cannot merge them: they are set on the rule of
+// the build file, if any, see syncAttrs.
+func newFormatTestRule(conf *Config, files []string) *rule.Rule {
+	sort.Strings(files)
+	r := rule.NewRule(formatTestRule, formatTestRuleName)
+	r.SetAttr("srcs", []string{conf.Formatter})
+	r.SetAttr("args", conf.formatArgs(files))
+	r.SetAttr("data", files)
+	return r
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestFormatTest(t *testing.T) {
+	testCases := []struct {
+		desc, directives, old, want string
+	}{
+		{
+			desc: "new",
+			directives: `# gazelle:jsonnet_format_test true
+# gazelle:jsonnet_format_options indent=2 string_style=s comment_style=s
+# gazelle:jsonnet_format_options pad_objects=false
+`,
+			want: `jsonnet_format_test(
+    name = "jsonnet_format_test",
+    srcs = ["@jsonnet_go//cmd/jsonnetfmt"],
+    args = [
+        "--test",
+        "--indent",
+        "2",
+        "--string-style",
+        "s",
+        "--comment-style",
+        "s",
+        "--no-pad-objects",
+        "$(location lib.libsonnet)",
+        "$(location main.jsonnet)",
+    ],
+    data = [
+        "lib.libsonnet",
+        "main.jsonnet",
+    ],
+)
+`,
+		}, {
+			desc: "stale",
+			directives: `# gazelle:jsonnet_format_test true
+# gazelle:jsonnet_formatter //tools:jsonnetfmt
+`,
+			old: `jsonnet_format_test(
+    name = "jsonnet_format_test",
+    srcs = ["@jsonnet_go//cmd/jsonnetfmt"],
+    args = [
+        "--test",
+        "--indent",
+        "4",
+        "$(location main.jsonnet)",
+        "$(location old.jsonnet)",
+    ],
+    data = [
+        "main.jsonnet",
+        "old.jsonnet",
+    ],
+)
+`,
+			want: `jsonnet_format_test(
+    name = "jsonnet_format_test",
+    srcs = ["//tools:jsonnetfmt"],
+    args = [
+        "--test",
+        "$(location lib.libsonnet)",
+        "$(location main.jsonnet)",
+    ],
+    data = [
+        "main.jsonnet",
+        "lib.libsonnet",
+    ],
+)
+`,
+		}, {
+			desc:       "off",
+			directives: "# gazelle:jsonnet_format_test false\n",
+			old: `jsonnet_format_test(
+    name = "jsonnet_format_test",
+    srcs = ["@jsonnet_go//cmd/jsonnetfmt"],
+    args = [
+        "--test",
+        "$(location main.jsonnet)",
+    ],
+    data = ["main.jsonnet"],
+)
+
+sh_test(
+    name = "other_test",
+    srcs = ["other_test.sh"],
+)
+`,
+			want: `sh_test(
+    name = "other_test",
+    srcs = ["other_test.sh"],
+)
+`,
+		}, {
+			desc:       "hand-written",
+			directives: "# gazelle:jsonnet_format_test false\n",
+			old: `sh_test(
+    name = "jsonnet_format_test",
+    srcs = ["format_test.sh"],
+    data = ["main.jsonnet"],
+)
+`,
+			want: `sh_test(
+    name = "jsonnet_format_test",
+    srcs = ["format_test.sh"],
+    data = ["main.jsonnet"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":  "import 'lib.libsonnet'",
+		"app/lib.libsonnet": "{}",
+		"app/data.json":     "{}",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.directives+tc.old)
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_format_test" && r.Kind() != "sh_test" {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), tc.directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatTestEmpty(t *testing.T) {
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": "{}",
+	})
+	defer os.RemoveAll(root)
+
+	// The test is only deleted from the packages it was generated in
+	for _, build := range []string{"", "jsonnet_format_test(name = \"jsonnet_format_test\")\n"} {
+		lang := jsonnet.NewLanguage()
+		c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+		lang.Configure(c, "", nil)
+		f, err := rule.LoadData(filepath.Join(root, "app", "BUILD.bazel"), "app", []byte(build))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lang.Configure(c, "app", f)
+		res := lang.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          filepath.Join(root, "app"),
+			Rel:          "app",
+			File:         f,
+			RegularFiles: []string{"main.jsonnet"},
+		})
+
+		var got bool
+		for _, r := range res.Empty {
+			got = got || r.Name() == "jsonnet_format_test"
+		}
+		if want := build != ""; got != want {
+			t.Errorf("build file %q: got empty format test %v; want %v", build, got, want)
+		}
+	}
+}
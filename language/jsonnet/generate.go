@@ -6,18 +6,28 @@ package jsonnet
 import (
 	"fmt"
 	"log"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
 )
 
 const (
-	dataImpPrivateAttr     = "_jsonnet_data_imports"
-	jsonnetImpPrivateAttr  = "_jsonnet_imports"
-	jsonnetSelfPrivateAttr = "_jsonnet_self"
+	dataImpPrivateAttr      = "_jsonnet_data_imports"
+	jsonnetImpPrivateAttr   = "_jsonnet_imports"
+	jsonnetPosPrivateAttr   = "_jsonnet_positions"
+	jsonnetMisPrivateAttr   = "_jsonnet_missing"
+	jsonnetKindPrivateAttr  = "_jsonnet_kinds"
+	jsonnetStrPrivateAttr   = "_jsonnet_import_strs"
+	jsonnetSelfPrivateAttr  = "_jsonnet_self"
+	externalPrivateAttr     = "_jsonnet_external"
+	externalDataPrivateAttr = "_jsonnet_external_data"
 )
 
 // GenerateRules implements language.Language
@@ -29,6 +39,16 @@ func (l *Lang) GenerateRules(args language.GenerateArgs) language.GenerateResult
 		return res
 	}
 
+	// Vendored modules are provided by a library each, generated in the
+	// vendor directory.
+	if conf.Bundle.IsVendored(args.Rel) {
+		if args.Rel == conf.Bundle.Vendor {
+			res.Gen = l.newVendorRules(args.Config, conf.Bundle)
+			res.Imports = make([]interface{}, len(res.Gen))
+		}
+		return res
+	}
+
 	// Generate map of existing files in the current package
 	// to avoid iterating the array each time we want to check
 	// if a file exists already.
@@ -38,27 +58,92 @@ func (l *Lang) GenerateRules(args language.GenerateArgs) language.GenerateResult
 	for _, name := range args.RegularFiles {
 		pkgFiles[filepath.Join(args.Rel, name)] = true
 	}
+	// Golden files are found by name, before outputs are added to pkgFiles
+	files := make(map[string]bool, len(args.RegularFiles))
+	for _, name := range args.RegularFiles {
+		files[name] = true
+	}
 
+	// Record generated files, so imports of them are not reported
+	// as missing in Resolve.
+	for _, name := range args.GenFiles {
+		l.genFiles[filepath.Join(args.Rel, name)] = true
+	}
+
+	var nativeFiles []string
 	for _, name := range args.RegularFiles {
 		if !conf.IsNativeImport(filepath.Ext(name)) {
 			continue
 		}
+		nativeFiles = append(nativeFiles, name)
 		finfo, err := NewFileInfo(args.Config, args.Dir, args.Rel, name, &Importer{l.Importer})
 		if err != nil {
-			log.Printf("%v", err)
+			// Files expected to fail may not even parse, e.g. with a syntax error.
+			// They are only tested, and not reported.
+			if gen, empty := newUnparsedTestRules(args, conf, name, files); len(gen) > 0 {
+				res.Gen = append(res.Gen, gen...)
+				res.Empty = append(res.Empty, empty...)
+			} else {
+				log.Printf("%v", err)
+			}
 			continue
 		}
 		if finfo == nil {
 			continue
 		}
+		l.graph.Add(*finfo)
 		res.Gen = append(res.Gen, newLibraryRule(*finfo))
-		res.Gen = append(res.Gen, newToJSONRule(*finfo, pkgFiles))
+		// Files can have settings of their own, see forFile
+		fconf := conf.forFile(*finfo)
+		// Files expected to fail are only tested
+		if isErrorFile(fconf, *finfo, files) {
+			res.Empty = append(res.Empty, rule.NewRule(toJSONRule, finfo.Path.RuleName(toJSONRulePrefix)))
+		} else {
+			gen, empty := l.newToJSONRules(args, fconf, *finfo, pkgFiles)
+			res.Gen = append(res.Gen, gen...)
+			res.Empty = append(res.Empty, empty...)
+		}
+		gen, empty := l.newTestRules(args, fconf, *finfo, files)
+		res.Gen = append(res.Gen, gen...)
+		res.Empty = append(res.Empty, empty...)
 	}
 
+	// The formatting of the files is tested, including the ones that fail to parse
+	if conf.FormatTest && len(nativeFiles) > 0 {
+		r := newFormatTestRule(conf, nativeFiles)
+		syncAttrs(args.File, r, "args")
+		res.Gen = append(res.Gen, r)
+	} else if hasRule(args.File, formatTestRule, formatTestRuleName) {
+		res.Empty = append(res.Empty, rule.NewRule(formatTestRule, formatTestRuleName))
+	}
+
+	// Generated jsonnet files are wrapped in a library, unless one exists already
+	for _, name := range args.GenFiles {
+		if !conf.IsNativeImport(filepath.Ext(name)) || hasLibrary(args.File, name) {
+			continue
+		}
+		fpath, err := fileinfo.NewFilePath(args.Config.RepoRoot, args.Rel, name)
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		res.Gen = append(res.Gen, newGeneratedLibraryRule(fpath))
+	}
+
+	gen, empty := l.newAggregateRules(args, conf, res.Gen, res.Empty)
+	res.Gen = append(res.Gen, gen...)
+	res.Empty = append(res.Empty, empty...)
+
 	sort.SliceStable(res.Gen, func(i, j int) bool {
 		return res.Gen[i].Name() < res.Gen[j].Name()
 	})
 
+	// Values annotated with "# keep" in the existing rules are resolved along
+	// with the generated ones, so they are not duplicated.
+	for _, r := range res.Gen {
+		copyKeptValues(args.File, r)
+	}
+
 	res.Imports = make([]interface{}, len(res.Gen))
 	for i, r := range res.Gen {
 		switch r.Kind() {
@@ -66,7 +151,7 @@ func (l *Lang) GenerateRules(args language.GenerateArgs) language.GenerateResult
 			// The rule contains a private attribute with the imports
 			// that we want to resolve back to deps in Resolve.
 			res.Imports[i] = r.PrivateAttr(jsonnetImpPrivateAttr)
-		case toJSONRule:
+		case toJSONRule, toJSONTestRule:
 			// The rule contains a private attribute with the reference
 			// to its own source file that we want to resolve back to
 			// its deps in Resolve.
@@ -90,13 +175,21 @@ func (l *Lang) GenerateRules(args language.GenerateArgs) language.GenerateResult
 // srcs: 	[required] List of .jsonnet files that comprises this Jsonnet library.
 // deps: 	<optional> List of targets that are required by the srcs Jsonnet files.
 //
-// This rule implementation will not generate (yet) rules with:
-//
 // imports: <optional> List of import -J flags to be passed to the jsonnet compiler.
+//			Only generated for the roots of import aliases, and the libraries of vendored
+//			modules, see newVendorRules.
 func newLibraryRule(finfo fileinfo.FileInfo) *rule.Rule {
 	name := finfo.Path.RuleName(libraryRulePrefix)
 	r := rule.NewRule(libraryRule, name)
 	r.SetAttr("srcs", []string{finfo.Path.Filename})
+	if len(finfo.ImportRoots) > 0 {
+		// Library paths are relative to the package of the rule
+		imports := make([]string, len(finfo.ImportRoots))
+		for i, root := range finfo.ImportRoots {
+			imports[i], _ = filepath.Rel(filepath.Join("/", finfo.Path.Package), filepath.Join("/", root))
+		}
+		r.SetAttr("imports", imports)
+	}
 	r.SetAttr("visibility", []string{"//visibility:public"})
 
 	// Mark jsonnet imports
@@ -113,9 +206,178 @@ func newLibraryRule(finfo fileinfo.FileInfo) *rule.Rule {
 	}
 	r.SetPrivateAttr(dataImpPrivateAttr, dataImports)
 
+	// Mark import positions, kinds and strings, so diagnostics can point at the
+	// import expressions as written
+	positions := make(map[string]fileinfo.Position, len(finfo.Positions))
+	for imp, pos := range finfo.Positions {
+		positions[imp] = pos
+	}
+	r.SetPrivateAttr(jsonnetPosPrivateAttr, positions)
+	kinds := make(map[string]fileinfo.ImportKind, len(finfo.Kinds))
+	for imp, kind := range finfo.Kinds {
+		kinds[imp] = kind
+	}
+	r.SetPrivateAttr(jsonnetKindPrivateAttr, kinds)
+	importStrs := make(map[string]string, len(finfo.ImportStrs))
+	for imp, str := range finfo.ImportStrs {
+		importStrs[imp] = str
+	}
+	r.SetPrivateAttr(jsonnetStrPrivateAttr, importStrs)
+
+	// Mark imports whose target was not found in the filesystem
+	missing := make(map[string]bool, len(finfo.Missing))
+	for imp := range finfo.Missing {
+		missing[imp] = true
+	}
+	r.SetPrivateAttr(jsonnetMisPrivateAttr, missing)
+
+	// Mark imports resolved to external repositories
+	external := make(map[string]label.Label, len(finfo.External))
+	for imp, l := range finfo.External {
+		external[imp] = l
+	}
+	r.SetPrivateAttr(externalPrivateAttr, external)
+	externalData := make(map[string]label.Label, len(finfo.ExternalData))
+	for imp, l := range finfo.ExternalData {
+		externalData[imp] = l
+	}
+	r.SetPrivateAttr(externalDataPrivateAttr, externalData)
+
+	// Mark the file itself, so its import cycle can be found in Resolve
+	r.SetPrivateAttr(jsonnetSelfPrivateAttr, map[string]fileinfo.FilePath{finfo.Path.Filename: finfo.Path})
+
 	return r
 }
 
+// newToJSONRules returns the jsonnet_to_json rules of a file: a rule per variant if
+// the file reads the external variable of the variants, and a single rule otherwise.
+// The rules of the file that are no longer generated, e.g. the rules of removed
+// variants, are returned as empty rules, so they are deleted.
+func (l *Lang) newToJSONRules(args language.GenerateArgs, conf *Config, finfo fileinfo.FileInfo, pkgFiles map[string]bool) (gen, empty []*rule.Rule) {
+	vars := l.extVars(args.Config, finfo)
+	variants := []string{""}
+	if conf.Variants != nil {
+		if _, found := vars[conf.Variants.Var]; found {
+			variants = conf.Variants.Values
+		}
+	}
+	if conf.SkipToJSON[finfo.Path.Path] {
+		variants = nil
+	}
+
+	// Functions cannot be evaluated without their top-level arguments
+	if finfo.Function != nil {
+		if missing := missingTLAs(conf, finfo.Function); len(missing) > 0 {
+			log.Printf("%s: no %s is generated for %s: its top-level function has no argument for %s: set it with the %s directive",
+				finfo.Function.Pos, toJSONRule, finfo.Path.Filename, strings.Join(missing, ", "), tlaDirective)
+			variants = nil
+		}
+	}
+
+	names := map[string]bool{}
+	for _, variant := range variants {
+		vconf := conf
+		if variant != "" {
+			vconf = conf.forVariant(variant)
+		}
+		r := newToJSONRule(vconf, finfo, variant, pkgFiles)
+		// Gazelle cannot merge the values of the external variables and of the
+		// top-level arguments
+		setExtVarAttrs(vconf, r, finfo.Path, vars)
+		setTLAAttrs(vconf, r, finfo.Function)
+		syncAttrs(args.File, r, append(extVarAttrs, tlaAttrs...)...)
+		gen = append(gen, r)
+		names[r.Name()] = true
+	}
+
+	if name := finfo.Path.RuleName(toJSONRulePrefix); !names[name] {
+		empty = append(empty, rule.NewRule(toJSONRule, name))
+	}
+	if args.File != nil {
+		for _, r := range args.File.Rules {
+			if _, ok := variantOf(r, finfo.Path); ok && !names[r.Name()] {
+				empty = append(empty, rule.NewRule(toJSONRule, r.Name()))
+			}
+		}
+	}
+	return gen, empty
+}
+
+// newGeneratedLibraryRule returns a jsonnet_library wrapping a generated file.
+// Its imports are unknown, as the file does not exist until it is built.
+func newGeneratedLibraryRule(fpath fileinfo.FilePath) *rule.Rule {
+	r := rule.NewRule(libraryRule, fpath.RuleName(libraryRulePrefix))
+	r.SetAttr("srcs", []string{fpath.Filename})
+	r.SetAttr("visibility", []string{"//visibility:public"})
+	return r
+}
+
+// hasRule returns whether a BUILD file has a rule of a kind with a name
+func hasRule(f *rule.File, kind, name string) bool {
+	if f == nil {
+		return false
+	}
+	for _, r := range f.Rules {
+		if r.Kind() == kind && r.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLibrary returns whether a jsonnet_library of a BUILD file has a file as source
+func hasLibrary(f *rule.File, name string) bool {
+	if f == nil {
+		return false
+	}
+	for _, r := range f.Rules {
+		if r.Kind() != libraryRule {
+			continue
+		}
+		for _, src := range r.AttrStrings("srcs") {
+			l, err := label.Parse(src)
+			if err == nil && l.Repo == "" && (l.Relative || l.Pkg == f.Pkg) && l.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyKeptValues copies the values annotated with "# keep" of the attributes
+// resolved for a generated rule from the rule of the same name and kind in a
+// build file, if any.
+func copyKeptValues(f *rule.File, r *rule.Rule) {
+	if f == nil {
+		return
+	}
+	for _, old := range f.Rules {
+		if old.Name() != r.Name() || old.Kind() != r.Kind() || old.ShouldKeep() {
+			continue
+		}
+		for key := range jsonnetKinds[r.Kind()].ResolveAttrs {
+			list, ok := old.Attr(key).(*bzl.ListExpr)
+			if !ok {
+				continue
+			}
+			var kept []bzl.Expr
+			for _, e := range list.List {
+				if str, ok := e.(*bzl.StringExpr); ok && rule.ShouldKeep(e) {
+					kept = append(kept, &bzl.StringExpr{Value: str.Value, Comments: str.Comments})
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			if current, ok := r.Attr(key).(*bzl.ListExpr); ok {
+				kept = append(current.List, kept...)
+			}
+			r.SetAttr(key, &bzl.ListExpr{List: kept, ForceMultiLine: true})
+		}
+		return
+	}
+}
+
 // https://github.com/bazelbuild/rules_jsonnet#user-content-jsonnet_to_json
 //
 // jsonnet_to_json
@@ -127,13 +389,18 @@ func newLibraryRule(finfo fileinfo.FileInfo) *rule.Rule {
 // outs:	[required]	Names of the output .json files to be generated by this rule.
 // deps:	<optinoal>	List of targets that are required by the src Jsonnet file.
 //
-// This rule implementation will not generate (yet) rules with:
-//
 // multiple_outputs:	<optional>	Default: 0. Set to 1 to explicitly enable multiple file output
-//									via the jsonnet -m flag.
-// imports:				<optional>	List of import -J flags to be passed to the jsonnet compiler.
-// stamp_keys:			<optional>	Specify which variables in ext_strs and ext_code should get stamped
-//									by listing the matching dict keys.
+//									via the jsonnet -m flag. Set for the files evaluating to an object
+//									whose keys are all filenames, which are the outs, or for the files
+//									given outputs with the jsonnet_multiple_outputs directive.
+// yaml_stream:			<optional>	Default: False. Set to 1 to write output as a YAML stream of JSON documents.
+//									Set for the files evaluating to a std.manifestYamlStream call or an array of
+//									Kubernetes objects, unless set otherwise with the jsonnet_output_format directive.
+//									Their out has a .yaml extension.
+//
+// The external variables read by the src file and the files it imports are given the
+// values of the jsonnet_ext_var directives, see setExtVarAttrs:
+//
 // ext_strs:			<optional>	Map of strings to pass to jsonnet as external variables via --ext-str key=value.
 // ext_str_envs:		<optional>	List of env var names containing strings to pass to jsonnet as external
 //									variables via --ext-str key.
@@ -148,15 +415,66 @@ func newLibraryRule(finfo fileinfo.FileInfo) *rule.Rule {
 //									at the same index and together are passed to jsonnet via --ext-code-file var=file.
 // ext_code_file_vars:	<optional>  List of var names that maps to the code file defined in code_files at the same index
 //									and together are passed to jsonnet via --ext-code-file var=file.
+// stamp_keys:			<optional>	Specify which variables in ext_strs and ext_code should get stamped
+//									by listing the matching dict keys. Set for the variables of the
+//									jsonnet_stamp_var directives.
+//
+// Files evaluating to a function are given the values of the jsonnet_tla directives
+// for its parameters, see setTLAAttrs. No rule is generated for them if a parameter
+// without default value has none:
+//
+// tla_strs:			<optional>	Map of strings to pass to jsonnet as top-level arguments via --tla-str key=value.
+// tla_code:			<optional>	Map of code to pass to jsonnet as top-level arguments via --tla-code key=value.
 // tla_code_files:		<optional>	Dict of labels referencing code files and a var name, passed to jsonnet via --tla-code-file var=file.
-// yaml_stream:			<optional>	Default: False. Set to 1 to write output as a YAML stream of JSON documents.
-func newToJSONRule(finfo fileinfo.FileInfo, pkgFiles map[string]bool) *rule.Rule {
-	name := finfo.Path.RuleName(toJSONRulePrefix)
+//
+// The rules of variants, see the jsonnet_variants directive, are named after them, e.g.
+// main_prod_to_json, and so are their outs, e.g. main.prod.json. Their external variable
+// is set to the variant.
+//
+// Files can set their outs, their external variables and their multiple outputs with
+// annotations, e.g. "// gazelle:output deployment.yaml", or have no rule with the
+// skip_to_json annotation, see forFile.
+//
+// This rule implementation will not generate (yet) rules with:
+//
+// imports:				<optional>	List of import -J flags to be passed to the jsonnet compiler.
+func newToJSONRule(conf *Config, finfo fileinfo.FileInfo, variant string, pkgFiles map[string]bool) *rule.Rule {
+	name := variantRuleName(finfo.Path, variant)
 	r := rule.NewRule(toJSONRule, name)
 	r.SetAttr("src", finfo.Path.Filename)
 
-	path := newOutput(finfo, ".json", pkgFiles)
-	r.SetAttr("outs", []string{filepath.Base(path)})
+	// The outputs of variants are named after them, e.g. main.prod.json
+	ext := ""
+	if variant != "" {
+		ext = "." + variant
+	}
+	if outputs := conf.MultipleOutputs(finfo); len(outputs) > 0 {
+		// The outputs are named by the file, so they cannot be renamed, but
+		// the ones of variants are written in a directory each.
+		outs := make([]string, len(outputs))
+		for i, out := range outputs {
+			outs[i] = path.Join(variant, out)
+			pkgFiles[filepath.Join(finfo.Path.Package, outs[i])] = true
+		}
+		r.SetAttr("outs", outs)
+		r.SetAttr("multiple_outputs", true)
+	} else if name, found := conf.OutputNames[finfo.Path.Path]; found {
+		// The output set with the output annotation is used as is, e.g.
+		// deployment.yaml, or deployment.prod.yaml for a variant
+		out := strings.TrimSuffix(name, path.Ext(name)) + ext + path.Ext(name)
+		pkgFiles[filepath.Join(finfo.Path.Package, out)] = true
+		r.SetAttr("outs", []string{out})
+		if conf.IsYAMLStream(finfo) {
+			r.SetAttr("yaml_stream", true)
+		}
+	} else if conf.IsYAMLStream(finfo) {
+		out := newOutput(finfo, ext+".yaml", pkgFiles)
+		r.SetAttr("outs", []string{filepath.Base(out)})
+		r.SetAttr("yaml_stream", true)
+	} else {
+		out := newOutput(finfo, ext+".json", pkgFiles)
+		r.SetAttr("outs", []string{filepath.Base(out)})
+	}
 
 	r.SetAttr("visibility", []string{"//visibility:public"})
 
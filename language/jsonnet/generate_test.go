@@ -0,0 +1,197 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestGenerateRulesGenFiles(t *testing.T) {
+	f, err := rule.LoadData("/root/schemas/BUILD.bazel", "schemas", []byte(`
+genrule(
+    name = "crds",
+    outs = ["crds.libsonnet", "data.json", "proto.libsonnet"],
+    cmd = "...",
+)
+
+jsonnet_library(
+    name = "protos",
+    srcs = [":proto.libsonnet"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := jsonnet.NewLanguage().GenerateRules(language.GenerateArgs{
+		Config:   &config.Config{RepoRoot: "/root", Exts: map[string]interface{}{}},
+		Dir:      "/root/schemas",
+		Rel:      "schemas",
+		File:     f,
+		GenFiles: []string{"crds.libsonnet", "data.json", "proto.libsonnet"},
+	})
+
+	if len(res.Gen) != 1 || len(res.Imports) != 1 {
+		t.Fatalf("got %d rules; want 1", len(res.Gen))
+	}
+	r := res.Gen[0]
+	if r.Kind() != "jsonnet_library" || r.Name() != "crds_library" {
+		t.Errorf("got %s %q; want jsonnet_library %q", r.Kind(), r.Name(), "crds_library")
+	}
+	if got, want := r.AttrStrings("srcs"), []string{"crds.libsonnet"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("srcs: got %q; want %q", got, want)
+	}
+}
+
+func TestGenerateRulesMultipleOutputs(t *testing.T) {
+	type outputs struct {
+		outs  []string
+		multi bool
+	}
+	testCases := []struct {
+		desc, build string
+		want        map[string]outputs
+	}{
+		{
+			desc: "detected",
+			want: map[string]outputs{
+				"manifests_to_json": {outs: []string{"deployment.json", "service.json"}, multi: true},
+				"config_to_json":    {outs: []string{"config.json"}},
+			},
+		}, {
+			desc: "forced",
+			build: `# gazelle:jsonnet_multiple_outputs config.jsonnet a.json b.json
+# gazelle:jsonnet_multiple_outputs manifests.jsonnet off
+`,
+			want: map[string]outputs{
+				"manifests_to_json": {outs: []string{"manifests.json"}},
+				"config_to_json":    {outs: []string{"a.json", "b.json"}, multi: true},
+			},
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"manifests.jsonnet": "{ 'deployment.json': {}, 'service.json': {} }",
+		"config.jsonnet":    "{ [name + '.json']: {} for name in ['a', 'b'] }",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			lang := jsonnet.NewLanguage()
+			c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+			f, err := rule.LoadData(filepath.Join(root, "BUILD.bazel"), "", []byte(tc.build))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lang.Configure(c, "", f)
+			res := lang.GenerateRules(language.GenerateArgs{
+				Config:       c,
+				Dir:          root,
+				File:         f,
+				RegularFiles: []string{"config.jsonnet", "manifests.jsonnet"},
+			})
+
+			for _, r := range res.Gen {
+				want, found := tc.want[r.Name()]
+				if !found {
+					continue
+				}
+				if got := r.AttrStrings("outs"); !reflect.DeepEqual(got, want.outs) {
+					t.Errorf("%s: outs: got %q; want %q", r.Name(), got, want.outs)
+				}
+				if got := r.Attr("multiple_outputs") != nil; got != want.multi {
+					t.Errorf("%s: multiple_outputs: got %v; want %v", r.Name(), got, want.multi)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateRulesOutputFormat(t *testing.T) {
+	type output struct {
+		out    string
+		stream bool
+	}
+	testCases := []struct {
+		desc, build string
+		want        map[string]output
+	}{
+		{
+			desc: "auto",
+			want: map[string]output{
+				"stream_to_json":  {out: "stream.yaml", stream: true},
+				"objects_to_json": {out: "objects.yaml", stream: true},
+				"config_to_json":  {out: "config.json"},
+			},
+		}, {
+			desc:  "json",
+			build: "# gazelle:jsonnet_output_format json\n",
+			want: map[string]output{
+				"stream_to_json":  {out: "stream.json"},
+				"objects_to_json": {out: "objects.json"},
+				"config_to_json":  {out: "config.json"},
+			},
+		}, {
+			desc:  "yaml_stream",
+			build: "# gazelle:jsonnet_output_format yaml_stream\n",
+			want: map[string]output{
+				"stream_to_json":  {out: "stream.yaml", stream: true},
+				"objects_to_json": {out: "objects.yaml", stream: true},
+				"config_to_json":  {out: "config.yaml", stream: true},
+			},
+		},
+	}
+
+	files := map[string]string{
+		"stream.jsonnet":  "std.manifestYamlStream([{}])",
+		"objects.jsonnet": "[{ apiVersion: 'v1', kind: 'ConfigMap' }]",
+		"config.jsonnet":  "{ replicas: 1 }",
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	root := writeWorkspace(t, files)
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			lang := jsonnet.NewLanguage()
+			c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+			f, err := rule.LoadData(filepath.Join(root, "BUILD.bazel"), "", []byte(tc.build))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lang.Configure(c, "", f)
+			res := lang.GenerateRules(language.GenerateArgs{
+				Config:       c,
+				Dir:          root,
+				File:         f,
+				RegularFiles: names,
+			})
+
+			for _, r := range res.Gen {
+				want, found := tc.want[r.Name()]
+				if !found {
+					continue
+				}
+				if got := r.AttrStrings("outs"); !reflect.DeepEqual(got, []string{want.out}) {
+					t.Errorf("%s: outs: got %q; want %q", r.Name(), got, []string{want.out})
+				}
+				if got := r.Attr("yaml_stream") != nil; got != want.stream {
+					t.Errorf("%s: yaml_stream: got %v; want %v", r.Name(), got, want.stream)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+// Edge is an import between two nodes of an exported graph
+type Edge struct {
+	From string              `json:"from"`
+	To   string              `json:"to"`
+	Kind fileinfo.ImportKind `json:"kind"`
+	Pos  string              `json:"pos,omitempty"` // Only set for file edges
+}
+
+// Level is a set of nodes along with the imports between them
+type Level struct {
+	Nodes []string `json:"nodes"`
+	Edges []Edge   `json:"edges"`
+}
+
+// Export is a serializable view of the graph at both file and target level
+type Export struct {
+	Files   Level `json:"files"`   // Nodes are file paths, relative to the root of the workspace
+	Targets Level `json:"targets"` // Nodes are the labels of the targets providing the files
+}
+
+// Export returns the file and target level views of the graph. The target
+// function returns the label of the target providing an imported file, given
+// the kind of the import expression, or an empty string to leave the file out
+// of the target level.
+func (g *Graph) Export(target func(fpath fileinfo.FilePath, kind fileinfo.ImportKind) string) Export {
+	files := newLevel()
+	targets := newLevel()
+	for _, path := range g.Files() {
+		info := g.files[path]
+		from := target(info.Path, fileinfo.Import)
+		files.addNode(path)
+		if from != "" {
+			targets.addNode(from)
+		}
+
+		for _, imports := range []map[string]fileinfo.FilePath{info.Imports, info.DataImports} {
+			for imp, fpath := range imports {
+				kind := info.Kinds[imp]
+				to := target(fpath, kind)
+				files.addEdge(Edge{From: path, To: imp, Kind: kind, Pos: info.Positions[imp].String()})
+				// Files within the same target do not depend on each other
+				if from != "" && to != "" && from != to {
+					targets.addEdge(Edge{From: from, To: to, Kind: kind})
+				}
+			}
+		}
+	}
+	return Export{Files: files.level(), Targets: targets.level()}
+}
+
+// WriteJSON writes the graph as an indented JSON document
+func (e Export) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// WriteDOT writes the graph as two DOT digraphs, named files and targets.
+// Edges are labeled with their kind.
+func (e Export) WriteDOT(w io.Writer) error {
+	for _, l := range []struct {
+		name  string
+		level Level
+	}{{"files", e.Files}, {"targets", e.Targets}} {
+		if _, err := fmt.Fprintf(w, "digraph %s {\n", l.name); err != nil {
+			return err
+		}
+		for _, node := range l.level.Nodes {
+			if _, err := fmt.Fprintf(w, "\t%q;\n", node); err != nil {
+				return err
+			}
+		}
+		for _, edge := range l.level.Edges {
+			if _, err := fmt.Fprintf(w, "\t%q -> %q [label=%q];\n", edge.From, edge.To, edge.Kind); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprint(w, "}\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// levelBuilder collects unique nodes and edges
+type levelBuilder struct {
+	nodes map[string]bool
+	edges map[Edge]bool
+}
+
+func newLevel() *levelBuilder {
+	return &levelBuilder{nodes: make(map[string]bool), edges: make(map[Edge]bool)}
+}
+
+func (b *levelBuilder) addNode(node string) {
+	b.nodes[node] = true
+}
+
+func (b *levelBuilder) addEdge(edge Edge) {
+	b.addNode(edge.From)
+	b.addNode(edge.To)
+	b.edges[edge] = true
+}
+
+// level returns the nodes and edges, sorted
+func (b *levelBuilder) level() Level {
+	l := Level{Nodes: []string{}, Edges: []Edge{}}
+	for node := range b.nodes {
+		l.Nodes = append(l.Nodes, node)
+	}
+	sort.Strings(l.Nodes)
+	for edge := range b.edges {
+		l.Edges = append(l.Edges, edge)
+	}
+	sort.Slice(l.Edges, func(i, j int) bool {
+		a, b := l.Edges[i], l.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return l
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package graph_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/graph"
+)
+
+// target returns a label for each file, where data files are exposed by
+// a single target per package.
+func target(fpath fileinfo.FilePath, kind fileinfo.ImportKind) string {
+	if kind == fileinfo.ImportStr {
+		return "//" + fpath.Package + ":data"
+	}
+	return "//" + fpath.Package + ":" + fpath.RuleName("library")
+}
+
+func newExportGraph() *graph.Graph {
+	a := newFileInfo("x/a.jsonnet", "y/b.libsonnet")
+	a.Kinds = map[string]fileinfo.ImportKind{"y/b.libsonnet": fileinfo.Import}
+
+	b := newFileInfo("y/b.libsonnet")
+	data, _ := fileinfo.NewFilePath("/root", "y/c.txt")
+	b.DataImports = map[string]fileinfo.FilePath{"y/c.txt": data}
+	b.Positions["y/c.txt"] = fileinfo.Position{Filename: "y/b.libsonnet", Line: 3, Column: 5}
+	b.Kinds = map[string]fileinfo.ImportKind{"y/c.txt": fileinfo.ImportStr}
+
+	g := graph.New()
+	g.Add(a)
+	g.Add(b)
+	return g
+}
+
+func TestExport(t *testing.T) {
+	got := newExportGraph().Export(target)
+	want := graph.Export{
+		Files: graph.Level{
+			Nodes: []string{"x/a.jsonnet", "y/b.libsonnet", "y/c.txt"},
+			Edges: []graph.Edge{
+				{From: "x/a.jsonnet", To: "y/b.libsonnet", Kind: fileinfo.Import, Pos: "x/a.jsonnet:1:1"},
+				{From: "y/b.libsonnet", To: "y/c.txt", Kind: fileinfo.ImportStr, Pos: "y/b.libsonnet:3:5"},
+			},
+		},
+		Targets: graph.Level{
+			Nodes: []string{"//x:a_library", "//y:b_library", "//y:data"},
+			Edges: []graph.Edge{
+				{From: "//x:a_library", To: "//y:b_library", Kind: fileinfo.Import},
+				{From: "//y:b_library", To: "//y:data", Kind: fileinfo.ImportStr},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %#v; want: %#v", got, want)
+	}
+}
+
+func TestExportOmitted(t *testing.T) {
+	// Data files are left out of the target level
+	got := newExportGraph().Export(func(fpath fileinfo.FilePath, kind fileinfo.ImportKind) string {
+		if kind == fileinfo.ImportStr {
+			return ""
+		}
+		return target(fpath, kind)
+	})
+	want := graph.Level{
+		Nodes: []string{"//x:a_library", "//y:b_library"},
+		Edges: []graph.Edge{
+			{From: "//x:a_library", To: "//y:b_library", Kind: fileinfo.Import},
+		},
+	}
+	if !reflect.DeepEqual(got.Targets, want) {
+		t.Errorf("got: %#v; want: %#v", got.Targets, want)
+	}
+	if len(got.Files.Edges) != 2 {
+		t.Errorf("got %d file edges; want 2", len(got.Files.Edges))
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var b bytes.Buffer
+	if err := newExportGraph().Export(target).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph files {
+	"x/a.jsonnet";
+	"y/b.libsonnet";
+	"y/c.txt";
+	"x/a.jsonnet" -> "y/b.libsonnet" [label="import"];
+	"y/b.libsonnet" -> "y/c.txt" [label="importstr"];
+}
+digraph targets {
+	"//x:a_library";
+	"//y:b_library";
+	"//y:data";
+	"//x:a_library" -> "//y:b_library" [label="import"];
+	"//y:b_library" -> "//y:data" [label="importstr"];
+}
+`
+	if got := b.String(); got != want {
+		t.Errorf("got: %s; want: %s", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package graph builds the import graph of the jsonnet files in a workspace.
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+// Graph is a directed graph of jsonnet files, where each edge is an import
+type Graph struct {
+	files map[string]fileinfo.FileInfo
+}
+
+// Link is an import expression from a file to another
+type Link struct {
+	From string            // Importing file, relative to the root of the workspace
+	To   string            // Imported file, relative to the root of the workspace
+	Pos  fileinfo.Position // Location of the import expression
+}
+
+// Cycle is a set of files that import each other, directly or transitively
+type Cycle struct {
+	Files []string // Files in the cycle, sorted
+	Chain []Link   // Imports leading from Files[0] back to itself
+}
+
+// New returns an empty Graph
+func New() *Graph {
+	return &Graph{files: make(map[string]fileinfo.FileInfo)}
+}
+
+// Add adds a file and its imports to the graph, replacing any previous
+// information about the same file.
+func (g *Graph) Add(info fileinfo.FileInfo) {
+	g.files[info.Path.Path] = info
+}
+
+// File returns the information of a file in the graph
+func (g *Graph) File(path string) (fileinfo.FileInfo, bool) {
+	info, ok := g.files[path]
+	return info, ok
+}
+
+// Files returns the sorted paths of the files in the graph
+func (g *Graph) Files() []string {
+	paths := make([]string, 0, len(g.files))
+	for path := range g.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Imports returns the sorted jsonnet imports of a file that are part of the graph
+func (g *Graph) Imports(path string) []string {
+	var imports []string
+	for imp := range g.files[path].Imports {
+		if _, found := g.files[imp]; found {
+			imports = append(imports, imp)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// Dependents returns the sorted files of the graph affected by a change to any
+// of the given files, i.e. the given files themselves and every file importing
+// them, directly or transitively. Changed files may be data files, which are
+// not part of the graph but imported by files of the graph.
+func (g *Graph) Dependents(paths ...string) []string {
+	importers := make(map[string][]string)
+	for path, info := range g.files {
+		for _, imports := range []map[string]fileinfo.FilePath{info.Imports, info.DataImports} {
+			for imp := range imports {
+				importers[imp] = append(importers[imp], path)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	queue := append([]string{}, paths...)
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		queue = append(queue, importers[v]...)
+	}
+
+	var dependents []string
+	for path := range seen {
+		if _, found := g.files[path]; found {
+			dependents = append(dependents, path)
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Cycles returns the import cycles in the graph, sorted by their first file.
+//
+// Each cycle is a strongly connected component of the graph with more than one
+// file, or a single file importing itself.
+func (g *Graph) Cycles() []Cycle {
+	var cycles []Cycle
+	for _, scc := range g.components() {
+		if _, self := g.files[scc[0]].Imports[scc[0]]; len(scc) == 1 && !self {
+			continue
+		}
+		sort.Strings(scc)
+		cycles = append(cycles, Cycle{Files: scc, Chain: g.chain(scc)})
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].Files[0] < cycles[j].Files[0]
+	})
+	return cycles
+}
+
+// components returns the strongly connected components of the graph, using
+// Tarjan's algorithm.
+func (g *Graph) components() [][]string {
+	var (
+		index   = 0
+		indices = make(map[string]int, len(g.files))
+		lowlink = make(map[string]int, len(g.files))
+		onStack = make(map[string]bool, len(g.files))
+		stack   []string
+		sccs    [][]string
+	)
+
+	var connect func(v string)
+	connect = func(v string) {
+		indices[v] = index
+		lowlink[v] = index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.Imports(v) {
+			if _, visited := indices[w]; !visited {
+				connect(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && indices[w] < lowlink[v] {
+				lowlink[v] = indices[w]
+			}
+		}
+
+		if lowlink[v] == indices[v] {
+			var scc []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+	}
+
+	for _, v := range g.Files() {
+		if _, visited := indices[v]; !visited {
+			connect(v)
+		}
+	}
+	return sccs
+}
+
+// chain returns the shortest import chain from the first file of a strongly
+// connected component back to itself.
+func (g *Graph) chain(scc []string) []Link {
+	members := make(map[string]bool, len(scc))
+	for _, path := range scc {
+		members[path] = true
+	}
+
+	start := scc[0]
+	parents := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range g.Imports(v) {
+			if !members[w] {
+				continue
+			}
+			if w == start {
+				return g.links(append(path(parents, start, v), start))
+			}
+			if _, seen := parents[w]; !seen {
+				parents[w] = v
+				queue = append(queue, w)
+			}
+		}
+	}
+	return nil
+}
+
+// path returns the files leading from start to end, following parents
+func path(parents map[string]string, start, end string) []string {
+	files := []string{end}
+	for end != start {
+		end = parents[end]
+		files = append([]string{end}, files...)
+	}
+	return files
+}
+
+// links returns the import expressions between consecutive files
+func (g *Graph) links(files []string) []Link {
+	links := make([]Link, 0, len(files)-1)
+	for i := 0; i+1 < len(files); i++ {
+		links = append(links, Link{
+			From: files[i],
+			To:   files[i+1],
+			Pos:  g.files[files[i]].Positions[files[i+1]],
+		})
+	}
+	return links
+}
+
+// String returns the cycle as a chain of import expressions, one per line
+func (c Cycle) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "import cycle between %d file(s):", len(c.Files))
+	for _, link := range c.Chain {
+		fmt.Fprintf(&b, "\n\t%s: %s imports %s", link.Pos, link.From, link.To)
+	}
+	return b.String()
+}
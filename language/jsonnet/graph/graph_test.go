@@ -0,0 +1,158 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package graph_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/graph"
+)
+
+// newFileInfo returns a FileInfo for path importing imports. Each import
+// expression is placed on its own line.
+func newFileInfo(path string, imports ...string) fileinfo.FileInfo {
+	fpath, _ := fileinfo.NewFilePath("/root", path)
+	info := fileinfo.FileInfo{
+		Path:      fpath,
+		Imports:   make(map[string]fileinfo.FilePath),
+		Positions: make(map[string]fileinfo.Position),
+	}
+	for i, imp := range imports {
+		ipath, _ := fileinfo.NewFilePath("/root", imp)
+		info.Imports[imp] = ipath
+		info.Positions[imp] = fileinfo.Position{Filename: path, Line: i + 1, Column: 1}
+	}
+	return info
+}
+
+func TestCycles(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		files []fileinfo.FileInfo
+		want  []graph.Cycle
+	}{
+		{
+			desc: "no cycles",
+			files: []fileinfo.FileInfo{
+				newFileInfo("a.jsonnet", "b.libsonnet", "c.libsonnet"),
+				newFileInfo("b.libsonnet", "c.libsonnet"),
+				newFileInfo("c.libsonnet"),
+			},
+			want: nil,
+		},
+		{
+			desc: "self import",
+			files: []fileinfo.FileInfo{
+				newFileInfo("a.libsonnet", "a.libsonnet"),
+			},
+			want: []graph.Cycle{{
+				Files: []string{"a.libsonnet"},
+				Chain: []graph.Link{
+					{From: "a.libsonnet", To: "a.libsonnet", Pos: fileinfo.Position{Filename: "a.libsonnet", Line: 1, Column: 1}},
+				},
+			}},
+		},
+		{
+			desc: "cross-package cycle",
+			files: []fileinfo.FileInfo{
+				newFileInfo("main.jsonnet", "x/a.libsonnet"),
+				newFileInfo("x/a.libsonnet", "y/b.libsonnet"),
+				newFileInfo("y/b.libsonnet", "lib.libsonnet", "x/a.libsonnet"),
+				newFileInfo("lib.libsonnet"),
+			},
+			want: []graph.Cycle{{
+				Files: []string{"x/a.libsonnet", "y/b.libsonnet"},
+				Chain: []graph.Link{
+					{From: "x/a.libsonnet", To: "y/b.libsonnet", Pos: fileinfo.Position{Filename: "x/a.libsonnet", Line: 1, Column: 1}},
+					{From: "y/b.libsonnet", To: "x/a.libsonnet", Pos: fileinfo.Position{Filename: "y/b.libsonnet", Line: 2, Column: 1}},
+				},
+			}},
+		},
+		{
+			desc: "shortest chain",
+			files: []fileinfo.FileInfo{
+				newFileInfo("a.libsonnet", "b.libsonnet", "c.libsonnet"),
+				newFileInfo("b.libsonnet", "c.libsonnet"),
+				newFileInfo("c.libsonnet", "a.libsonnet"),
+			},
+			want: []graph.Cycle{{
+				Files: []string{"a.libsonnet", "b.libsonnet", "c.libsonnet"},
+				Chain: []graph.Link{
+					{From: "a.libsonnet", To: "c.libsonnet", Pos: fileinfo.Position{Filename: "a.libsonnet", Line: 2, Column: 1}},
+					{From: "c.libsonnet", To: "a.libsonnet", Pos: fileinfo.Position{Filename: "c.libsonnet", Line: 1, Column: 1}},
+				},
+			}},
+		},
+		{
+			desc: "imports outside the graph",
+			files: []fileinfo.FileInfo{
+				newFileInfo("a.libsonnet", "missing.libsonnet"),
+			},
+			want: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := graph.New()
+			for _, info := range tc.files {
+				g.Add(info)
+			}
+			got := g.Cycles()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got: %#v; want: %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCycleString(t *testing.T) {
+	g := graph.New()
+	g.Add(newFileInfo("x/a.libsonnet", "y/b.libsonnet"))
+	g.Add(newFileInfo("y/b.libsonnet", "x/a.libsonnet"))
+
+	want := "import cycle between 2 file(s):\n" +
+		"\tx/a.libsonnet:1:1: x/a.libsonnet imports y/b.libsonnet\n" +
+		"\ty/b.libsonnet:1:1: y/b.libsonnet imports x/a.libsonnet"
+	if got := g.Cycles()[0].String(); got != want {
+		t.Errorf("got: %q; want: %q", got, want)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	g := graph.New()
+	g.Add(newFileInfo("app/main.jsonnet", "lib/k8s.libsonnet"))
+	g.Add(newFileInfo("app/other.jsonnet", "lib/util.libsonnet"))
+	g.Add(newFileInfo("lib/k8s.libsonnet", "lib/util.libsonnet"))
+	g.Add(newFileInfo("lib/util.libsonnet"))
+	data, _ := fileinfo.NewFilePath("/root", "lib/data.json")
+	withData := newFileInfo("lib/data.libsonnet")
+	withData.DataImports = map[string]fileinfo.FilePath{"lib/data.json": data}
+	g.Add(withData)
+	g.Add(newFileInfo("app/data.jsonnet", "lib/data.libsonnet"))
+
+	testCases := []struct {
+		desc    string
+		changed []string
+		want    []string
+	}{
+		{"leaf", []string{"app/main.jsonnet"}, []string{"app/main.jsonnet"}},
+		{"library", []string{"lib/k8s.libsonnet"}, []string{"app/main.jsonnet", "lib/k8s.libsonnet"}},
+		{"transitive", []string{"lib/util.libsonnet"}, []string{"app/main.jsonnet", "app/other.jsonnet", "lib/k8s.libsonnet", "lib/util.libsonnet"}},
+		{"data", []string{"lib/data.json"}, []string{"app/data.jsonnet", "lib/data.libsonnet"}},
+		{"several", []string{"lib/k8s.libsonnet", "lib/data.json"}, []string{"app/data.jsonnet", "app/main.jsonnet", "lib/data.libsonnet", "lib/k8s.libsonnet"}},
+		{"unknown", []string{"README.md"}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := g.Dependents(tc.changed...)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got: %q; want: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -4,9 +4,14 @@
 package jsonnet
 
 import (
+	"path"
+	"sort"
+	"strings"
+
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/toolutils"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
 )
 
 // Importer hooks a jsonnet.Importer to parse an AST and obtain a list
@@ -15,6 +20,31 @@ type Importer struct {
 	Importer jsonnet.Importer
 }
 
+// Import is an import or importstr expression found in a snippet
+type Import struct {
+	File string              // Imported file name, as written in the snippet
+	Kind fileinfo.ImportKind // Kind of the expression
+	Pos  fileinfo.Position   // Location of the expression in the snippet
+}
+
+// ExtVar is an external variable read by a std.extVar call with a literal name
+type ExtVar struct {
+	Name string            // Name of the variable
+	Pos  fileinfo.Position // Location of the call in the snippet
+}
+
+// Snippet contains the information parsed from a snippet
+type Snippet struct {
+	Imports  []Import           // Import expressions, unique by file name
+	ExtVars  []ExtVar           // External variables, unique by name
+	Function *fileinfo.Function // Function the snippet evaluates to, if any
+	Outputs  []string           // Files written by jsonnet -m, if known. Sorted.
+	// Whether the snippet evaluates to a stream of YAML documents
+	YAMLStream bool
+	// Settings of the snippet, from the "// gazelle:key value" comments at its top
+	Annotations []fileinfo.Annotation
+}
+
 func visit(n ast.Node, f func(ast.Node)) {
 	f(n)
 	for _, c := range toolutils.Children(n) {
@@ -24,38 +54,210 @@ func visit(n ast.Node, f func(ast.Node)) {
 
 // ParseFileImports returns the file names referenced by import and importstr
 // expressions in a file.
-func ParseFileImports(filename string, i *Importer) ([]string, error) {
-	contents, _, err := i.Importer.Import("", filename)
+func ParseFileImports(filename string, i *Importer) ([]Import, error) {
+	s, err := ParseFile(filename, i)
 	if err != nil {
 		return nil, err
 	}
-	return i.ParseSnippetImports(filename, contents.String())
+	return s.Imports, nil
+}
+
+// ParseFile parses the imports and the external variables of a file.
+func ParseFile(filename string, i *Importer) (Snippet, error) {
+	contents, _, err := i.Importer.Import("", filename)
+	if err != nil {
+		return Snippet{}, err
+	}
+	return i.ParseSnippet(filename, contents.String())
 }
 
 // ParseSnippetImports returns the file names referenced by import and importstr
-// expressions in a snippet. It ensures uniqueness.
-func (i *Importer) ParseSnippetImports(filename string, snippet string) ([]string, error) {
-	node, err := jsonnet.SnippetToAST(filename, snippet)
+// expressions in a snippet, along with the position of their first occurrence.
+// It ensures uniqueness.
+func (i *Importer) ParseSnippetImports(filename string, snippet string) ([]Import, error) {
+	s, err := i.ParseSnippet(filename, snippet)
 	if err != nil {
 		return nil, err
 	}
+	return s.Imports, nil
+}
+
+// ParseSnippet returns the imports of a snippet, as ParseSnippetImports does, and
+// the external variables it reads with std.extVar, along with the position of their
+// first occurrence. Variables whose name is computed are left out.
+//
+// The annotations at the top of the snippet are parsed too. Imports ignored with
+// the ignore_import annotation are left out.
+func (i *Importer) ParseSnippet(filename string, snippet string) (Snippet, error) {
+	node, err := jsonnet.SnippetToAST(filename, snippet)
+	if err != nil {
+		return Snippet{}, err
+	}
+	annotations := parseAnnotations(filename, snippet)
+	ignored := map[string]bool{}
+	for _, a := range annotations {
+		if a.Key == ignoreImportAnnotation {
+			ignored[a.Value] = true
+		}
+	}
 
-	var files []string
+	var imports []Import
+	var extVars []ExtVar
 	seen := map[string]struct{}{}
-	collect := func(s string) {
-		if _, found := seen[s]; !found {
-			seen[s] = struct{}{}
-			files = append(files, s)
+	seenVars := map[string]int{}
+	collect := func(file *ast.LiteralString, kind fileinfo.ImportKind, loc *ast.LocationRange) {
+		if _, found := seen[file.Value]; !found && !ignored[file.Value] {
+			seen[file.Value] = struct{}{}
+			imports = append(imports, Import{
+				File: file.Value,
+				Kind: kind,
+				Pos:  fileinfo.Position{Filename: filename, Line: loc.Begin.Line, Column: loc.Begin.Column},
+			})
 		}
 	}
 	visit(node, func(n ast.Node) {
 		switch i := n.(type) {
 		case *ast.Import:
-			collect(i.File.Value)
+			collect(i.File, fileinfo.Import, i.Loc())
 		case *ast.ImportStr:
-			collect(i.File.Value)
+			collect(i.File, fileinfo.ImportStr, i.Loc())
+		case *ast.Apply:
+			name, ok := extVarName(i)
+			if !ok {
+				return
+			}
+			loc := i.Loc()
+			pos := fileinfo.Position{Filename: filename, Line: loc.Begin.Line, Column: loc.Begin.Column}
+			// The AST is not visited in the order of the source, e.g. the body
+			// of a local expression comes before its binds.
+			if j, found := seenVars[name]; !found {
+				seenVars[name] = len(extVars)
+				extVars = append(extVars, ExtVar{Name: name, Pos: pos})
+			} else if before(pos, extVars[j].Pos) {
+				extVars[j].Pos = pos
+			}
 		}
 	})
+	sort.SliceStable(extVars, func(i, j int) bool {
+		return before(extVars[i].Pos, extVars[j].Pos)
+	})
 
-	return files, nil
+	s := Snippet{Imports: imports, ExtVars: extVars, Annotations: annotations}
+	if obj, ok := topLevel(node).(*ast.DesugaredObject); ok {
+		s.Outputs = outputFiles(obj)
+	}
+	s.YAMLStream = isYAMLStream(topLevel(node))
+	if fn, ok := topLevel(node).(*ast.Function); ok {
+		loc := fn.Loc()
+		s.Function = &fileinfo.Function{Pos: fileinfo.Position{Filename: filename, Line: loc.Begin.Line, Column: loc.Begin.Column}}
+		for _, p := range fn.Parameters.Required {
+			s.Function.Params = append(s.Function.Params, fileinfo.Param{Name: string(p.Name)})
+		}
+		for _, p := range fn.Parameters.Optional {
+			s.Function.Params = append(s.Function.Params, fileinfo.Param{Name: string(p.Name), HasDefault: true})
+		}
+	}
+	return s, nil
+}
+
+// topLevel returns the expression a snippet evaluates to, after its locals
+func topLevel(node ast.Node) ast.Node {
+	for {
+		local, ok := node.(*ast.Local)
+		if !ok {
+			return node
+		}
+		node = local.Body
+	}
+}
+
+// before returns whether a position comes before another one in a file
+func before(a, b fileinfo.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// outputFiles returns the visible fields of an object, if they are all literal
+// filenames, e.g. {'deployment.json': ..., 'service.json': ...}. Objects with
+// computed fields cannot be known.
+func outputFiles(obj *ast.DesugaredObject) []string {
+	var files []string
+	for _, f := range obj.Fields {
+		// Hidden fields are not written, e.g. config:: {...}
+		if f.Hide == ast.ObjectFieldHidden {
+			continue
+		}
+		name, ok := f.Name.(*ast.LiteralString)
+		if !ok || !isFilename(name.Value) {
+			return nil
+		}
+		files = append(files, name.Value)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// isYAMLStream returns whether an expression is a call to std.manifestYamlStream,
+// or an array of Kubernetes objects, i.e. objects with apiVersion and kind fields.
+func isYAMLStream(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.Apply:
+		return isStdCall(n, "manifestYamlStream")
+	case *ast.Array:
+		for _, e := range n.Elements {
+			obj, ok := e.Expr.(*ast.DesugaredObject)
+			if !ok || !hasFields(obj, "apiVersion", "kind") {
+				return false
+			}
+		}
+		return len(n.Elements) > 0
+	}
+	return false
+}
+
+// hasFields returns whether an object has fields of the given literal names
+func hasFields(obj *ast.DesugaredObject, names ...string) bool {
+	fields := map[string]bool{}
+	for _, f := range obj.Fields {
+		if name, ok := f.Name.(*ast.LiteralString); ok {
+			fields[name.Value] = true
+		}
+	}
+	for _, name := range names {
+		if !fields[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// isFilename returns whether a string is a relative file path with an extension
+func isFilename(s string) bool {
+	return path.Ext(s) != "" && path.Clean(s) == s && !path.IsAbs(s) && !strings.HasPrefix(s, "../")
+}
+
+// isStdCall returns whether a call is a call to a function of the standard
+// library. std.f(...) is desugared to std['f'](...).
+func isStdCall(call *ast.Apply, name string) bool {
+	index, ok := call.Target.(*ast.Index)
+	if !ok {
+		return false
+	}
+	if std, ok := index.Target.(*ast.Var); !ok || std.Id != "std" {
+		return false
+	}
+	fn, ok := index.Index.(*ast.LiteralString)
+	return ok && fn.Value == name
+}
+
+// extVarName returns the name of the variable read by a std.extVar call, if it
+// is a literal string.
+func extVarName(call *ast.Apply) (string, bool) {
+	if !isStdCall(call, "extVar") || len(call.Arguments.Positional) != 1 {
+		return "", false
+	}
+	name, ok := call.Arguments.Positional[0].Expr.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	return name.Value, true
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
 	gojsonnet "github.com/google/go-jsonnet"
 )
 
@@ -15,7 +16,7 @@ func TestParseSnippetImports(t *testing.T) {
 	testCases := []struct {
 		desc    string
 		snippet string
-		want    []string
+		want    []jsonnet.Import
 	}{
 		{
 			desc:    "empty",
@@ -25,37 +26,48 @@ func TestParseSnippetImports(t *testing.T) {
 		{
 			desc:    "simple import",
 			snippet: "import 'a.jsonnet'",
-			want:    []string{"a.jsonnet"},
+			want:    []jsonnet.Import{{File: "a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 1}}},
 		},
 		{
 			desc:    "arbitrary import",
 			snippet: "(import 'a.f.o.o')",
-			want:    []string{"a.f.o.o"},
+			want:    []jsonnet.Import{{File: "a.f.o.o", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}}},
 		},
 		{
 			desc:    "consecutive import",
 			snippet: "(import 'a.jsonnet') + (import 'b.jsonnet')",
-			want:    []string{"a.jsonnet", "b.jsonnet"},
+			want: []jsonnet.Import{
+				{File: "a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}},
+				{File: "b.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 25}},
+			},
 		},
 		{
 			desc:    "repeated import",
 			snippet: "(import 'a.jsonnet') + (import 'a.jsonnet')",
-			want:    []string{"a.jsonnet"},
+			want:    []jsonnet.Import{{File: "a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}}},
 		},
 		{
 			desc:    "parent import",
 			snippet: "(import '../a.jsonnet')",
-			want:    []string{"../a.jsonnet"},
+			want:    []jsonnet.Import{{File: "../a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}}},
 		},
 		{
 			desc:    "subfolder import",
 			snippet: "(import 'b/a.jsonnet')",
-			want:    []string{"b/a.jsonnet"},
+			want:    []jsonnet.Import{{File: "b/a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}}},
+		},
+		{
+			desc:    "multiline import",
+			snippet: "local a = import 'a.jsonnet';\n\n{\n  b: importstr 'b.json',\n}",
+			want: []jsonnet.Import{
+				{File: "b.json", Kind: fileinfo.ImportStr, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 4, Column: 6}},
+				{File: "a.jsonnet", Kind: fileinfo.Import, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 11}},
+			},
 		},
 		{
 			desc:    "simple importstr",
 			snippet: "(importstr 'a.json')",
-			want:    []string{"a.json"},
+			want:    []jsonnet.Import{{File: "a.json", Kind: fileinfo.ImportStr, Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 2}}},
 		},
 	}
 
@@ -68,8 +80,242 @@ func TestParseSnippetImports(t *testing.T) {
 				t.Fatal(err)
 			}
 			if !reflect.DeepEqual(got, tc.want) {
-				t.Errorf("got %q; want %q", got, tc.want)
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSnippetExtVars(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		snippet string
+		want    []jsonnet.ExtVar
+	}{
+		{
+			desc:    "none",
+			snippet: "{}",
+			want:    nil,
+		},
+		{
+			desc:    "literal name",
+			snippet: "std.extVar('env')",
+			want:    []jsonnet.ExtVar{{Name: "env", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 1}}},
+		},
+		{
+			desc:    "repeated",
+			snippet: "local env = std.extVar('env');\n{ a: env, b: std.extVar(\"env\"), c: std.extVar('region') }",
+			want: []jsonnet.ExtVar{
+				{Name: "env", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 13}},
+				{Name: "region", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 2, Column: 36}},
+			},
+		},
+		{
+			desc:    "computed name",
+			snippet: "local name = 'env'; std.extVar(name)",
+			want:    nil,
+		},
+		{
+			desc:    "shadowed std",
+			snippet: "local lib = { extVar(name): name }; lib.extVar('env')",
+			want:    nil,
+		},
+	}
+
+	filename := "test.jsonnet"
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := importer.ParseSnippet(filename, tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.ExtVars, tc.want) {
+				t.Errorf("got %v; want %v", got.ExtVars, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSnippetFunction(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		snippet string
+		want    *fileinfo.Function
+	}{
+		{
+			desc:    "object",
+			snippet: "{}",
+			want:    nil,
+		},
+		{
+			desc:    "function",
+			snippet: "function(env, replicas=1) { env: env }",
+			want: &fileinfo.Function{
+				Pos:    fileinfo.Position{Filename: "test.jsonnet", Line: 1, Column: 1},
+				Params: []fileinfo.Param{{Name: "env"}, {Name: "replicas", HasDefault: true}},
+			},
+		},
+		{
+			desc:    "after locals",
+			snippet: "local lib = import 'lib.libsonnet';\nlocal defaults = {};\n(function(config=defaults) lib + config)",
+			want: &fileinfo.Function{
+				Pos:    fileinfo.Position{Filename: "test.jsonnet", Line: 3, Column: 2},
+				Params: []fileinfo.Param{{Name: "config", HasDefault: true}},
+			},
+		},
+		{
+			desc:    "nested function",
+			snippet: "{ f: function(a) a }",
+			want:    nil,
+		},
+	}
+
+	filename := "test.jsonnet"
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := importer.ParseSnippet(filename, tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Function, tc.want) {
+				t.Errorf("got %+v; want %+v", got.Function, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSnippetOutputs(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		snippet string
+		want    []string
+	}{
+		{
+			desc:    "filenames",
+			snippet: "local app = 'app';\n{ 'service.json': {}, 'deployment.json': {}, config:: {} }",
+			want:    []string{"deployment.json", "service.json"},
+		},
+		{
+			desc:    "subdirectories",
+			snippet: "{ 'manifests/deployment.yaml': {} }",
+			want:    []string{"manifests/deployment.yaml"},
+		},
+		{
+			desc:    "not filenames",
+			snippet: "{ 'deployment.json': {}, service: {} }",
+			want:    nil,
+		},
+		{
+			desc:    "computed",
+			snippet: "{ 'deployment.json': {}, [std.toString(1) + '.json']: {} }",
+			want:    nil,
+		},
+		{
+			desc:    "out of the package",
+			snippet: "{ '../deployment.json': {} }",
+			want:    nil,
+		},
+		{
+			desc:    "composed",
+			snippet: "{ 'deployment.json': {} } + { 'service.json': {} }",
+			want:    nil,
+		},
+	}
+
+	filename := "test.jsonnet"
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := importer.ParseSnippet(filename, tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Outputs, tc.want) {
+				t.Errorf("got %q; want %q", got.Outputs, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSnippetYAMLStream(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		snippet string
+		want    bool
+	}{
+		{
+			desc:    "object",
+			snippet: "{ apiVersion: 'v1', kind: 'Service' }",
+			want:    false,
+		},
+		{
+			desc:    "manifestYamlStream",
+			snippet: "local objects = [];\nstd.manifestYamlStream(objects)",
+			want:    true,
+		},
+		{
+			desc:    "kubernetes objects",
+			snippet: "[{ apiVersion: 'v1', kind: 'Service' }, { apiVersion: 'apps/v1', kind: 'Deployment', spec: {} }]",
+			want:    true,
+		},
+		{
+			desc:    "other objects",
+			snippet: "[{ apiVersion: 'v1', kind: 'Service' }, { name: 'app' }]",
+			want:    false,
+		},
+		{
+			desc:    "empty array",
+			snippet: "[]",
+			want:    false,
+		},
+	}
+
+	filename := "test.jsonnet"
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := importer.ParseSnippet(filename, tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.YAMLStream != tc.want {
+				t.Errorf("got %v; want %v", got.YAMLStream, tc.want)
 			}
 		})
 	}
 }
+
+func TestParseSnippetAnnotations(t *testing.T) {
+	snippet := `// Copyright 2019 VMware, Inc.
+/* Rendered for
+   every environment */
+  // gazelle:output deployment.yaml
+// gazelle:ignore_import  vendor/k.libsonnet
+// gazelle:skip_to_json
+
+local k = import 'vendor/k.libsonnet';
+// gazelle:ext_var env str dev
+local lib = import 'lib.libsonnet';
+k + lib
+`
+	importer := &jsonnet.Importer{&gojsonnet.FileImporter{}}
+	got, err := importer.ParseSnippet("test.jsonnet", snippet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Comments after the first expression are not annotations
+	want := []fileinfo.Annotation{
+		{Key: "output", Value: "deployment.yaml", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 4, Column: 3}},
+		{Key: "ignore_import", Value: "vendor/k.libsonnet", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 5, Column: 1}},
+		{Key: "skip_to_json", Pos: fileinfo.Position{Filename: "test.jsonnet", Line: 6, Column: 1}},
+	}
+	if !reflect.DeepEqual(got.Annotations, want) {
+		t.Errorf("annotations: got %+v; want %+v", got.Annotations, want)
+	}
+	if len(got.Imports) != 1 || got.Imports[0].File != "lib.libsonnet" {
+		t.Errorf("imports: got %+v; want lib.libsonnet only", got.Imports)
+	}
+}
@@ -3,7 +3,11 @@
 
 package jsonnet
 
-import "github.com/bazelbuild/bazel-gazelle/rule"
+import (
+	"os"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
 
 const (
 	libraryRule       = "jsonnet_library"
@@ -11,15 +15,35 @@ const (
 
 	toJSONRule       = "jsonnet_to_json"
 	toJSONRulePrefix = "to_json"
+
+	toJSONTestRule = "jsonnet_to_json_test"
+	testRulePrefix = "test"
+
+	gitRepositoryRule = "new_git_repository"
+
+	// formatTestRule is a sh_test of def.bzl, so the tests written by hand are
+	// not merged with the generated ones
+	formatTestRule = "jsonnet_format_test"
+	// outputsRule is a filegroup of def.bzl, so the filegroups written by hand
+	// are not merged with the generated ones
+	outputsRule = "jsonnet_outputs"
 )
 
 var (
 	// https://github.com/bazelbuild/rules_jsonnet
 	jsonnetKinds = map[string]rule.KindInfo{
 		libraryRule: {
-			NonEmptyAttrs:  map[string]bool{"srcs": true},
-			MergeableAttrs: map[string]bool{"srcs": true},
-			ResolveAttrs:   map[string]bool{"deps": true},
+			NonEmptyAttrs: map[string]bool{"srcs": true},
+			MergeableAttrs: map[string]bool{
+				"srcs":    true,
+				"imports": true,
+			},
+			// srcs are also completed in Resolve, with data imports and
+			// the files of merged import cycles.
+			ResolveAttrs: map[string]bool{
+				"srcs": true,
+				"deps": true,
+			},
 		},
 		toJSONRule: {
 			NonEmptyAttrs: map[string]bool{
@@ -27,22 +51,61 @@ var (
 				"outs": true,
 			},
 			MergeableAttrs: map[string]bool{
-				"src":  true,
-				"outs": true,
+				"src":              true,
+				"outs":             true,
+				"multiple_outputs": true,
+				"yaml_stream":      true,
 			},
 			ResolveAttrs: map[string]bool{"deps": true},
 		},
-	}
-	jsonnetLoads = []rule.LoadInfo{
-		{
-			Name: "@io_bazel_rules_jsonnet//jsonnet:jsonnet.bzl",
-			Symbols: []string{
-				libraryRule,
-				toJSONRule,
+		toJSONTestRule: {
+			NonEmptyAttrs: map[string]bool{
+				"src":    true,
+				"golden": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"src":         true,
+				"golden":      true,
+				"error":       true,
+				"yaml_stream": true,
+			},
+			ResolveAttrs: map[string]bool{"deps": true},
+		},
+		// Tests of the formatting of the jsonnet files of a package. Their args
+		// are ordered, so they are not merged, see newFormatTestRule.
+		formatTestRule: {
+			NonEmptyAttrs: map[string]bool{"data": true},
+			MergeableAttrs: map[string]bool{
+				"srcs": true,
+				"data": true,
+			},
+		},
+		// Aggregates of the outputs of a package, see newAggregateRules
+		outputsRule: {
+			NonEmptyAttrs:  map[string]bool{"srcs": true},
+			MergeableAttrs: map[string]bool{"srcs": true},
+		},
+		// Repository rules imported from jsonnet-bundler lock files
+		gitRepositoryRule: {
+			NonEmptyAttrs: map[string]bool{"remote": true},
+			MergeableAttrs: map[string]bool{
+				"build_file_content": true,
+				"commit":             true,
+				"remote":             true,
+				"strip_prefix":       true,
 			},
 		},
 	}
 )
 
 func (*Lang) Kinds() map[string]rule.KindInfo { return jsonnetKinds }
-func (*Lang) Loads() []rule.LoadInfo          { return jsonnetLoads }
+
+// Loads returns the loads of the rules of the language. Gazelle asks for them
+// before parsing its flags and the build files, so they are computed for the
+// workspace found from its command line, see findRulesRepo.
+func (l *Lang) Loads() []rule.LoadInfo {
+	if l.rulesRepo == "" {
+		l.rulesRepo = findRulesRepo(os.Args[1:])
+	}
+	return newLoads(l.rulesRepo)
+}
@@ -23,8 +23,11 @@
 package jsonnet
 
 import (
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/google/go-jsonnet"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/graph"
 )
 
 const (
@@ -35,11 +38,39 @@ const (
 type Lang struct {
 	// Importer hooks a jsonnet.Importer to implement a jsonnet AST parser.
 	Importer jsonnet.Importer
+
+	// genFiles contains the files generated by rules in the workspace,
+	// relative to the root of the workspace.
+	genFiles map[string]bool
+
+	// graph contains the imports of every jsonnet file in the workspace.
+	graph *graph.Graph
+	// cycles maps the files in an import cycle to their cycle. It is computed
+	// on the first call to Resolve, once every package has been visited.
+	cycles map[string]*graph.Cycle
+	// parsed contains the files parsed before their package is visited, to find
+	// the external variables read by the files importing them.
+	parsed map[string]*fileinfo.FileInfo
+	// configs contains the configuration of each configured directory, so imported
+	// files are parsed with the directives of their own directory.
+	configs map[string]*config.Config
+	// outputGroups contains the packages with a filegroup of their outputs, which
+	// the filegroups of their parent packages include if recursive.
+	outputGroups map[string]bool
+
+	// rulesRepo is the repository the loads of the jsonnet rules are computed for
+	rulesRepo string
 }
 
 // NewLanguage implements the language.Language interface
 func NewLanguage() language.Language {
 	return &Lang{
 		Importer: &jsonnet.FileImporter{},
+		genFiles: make(map[string]bool),
+		graph:    graph.New(),
+		parsed:   make(map[string]*fileinfo.FileInfo),
+		configs:  make(map[string]*config.Config),
+
+		outputGroups: make(map[string]bool),
 	}
 }
@@ -0,0 +1,167 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+var repoNameRe = regexp.MustCompile(`[^\w.-]+`)
+
+// CanImport implements language.RepoImporter
+func (*Lang) CanImport(path string) bool {
+	return filepath.Base(path) == bundler.LockFile
+}
+
+// ImportRepos implements language.RepoImporter. Each git dependency of a
+// jsonnet-bundler lock file becomes a new_git_repository rule whose BUILD file
+// exposes a jsonnet_library.
+//
+// Repositories are named after the legacy name of their module, and their
+// libraries add the directory containing the external repositories to the
+// library paths. Therefore, modules can be imported by legacy name, as
+// jsonnet-bundler allows, e.g. import 'grafonnet/grafana.libsonnet'.
+//
+// If the modules are still vendored next to the lock file, the libraries
+// depend on the libraries of the other modules they import.
+func (l *Lang) ImportRepos(args language.ImportReposArgs) language.ImportReposResult {
+	f, err := bundler.ReadFile(args.Path)
+	if err != nil {
+		return language.ImportReposResult{Error: err}
+	}
+	b := loadVendoredBundle(args.Config, args.Path)
+
+	var res language.ImportReposResult
+	names := map[string]bool{}
+	for _, dep := range f.Dependencies {
+		if dep.Source.Git == nil {
+			log.Printf("%s: %s: only git dependencies can be imported", args.Path, dep.LegacyName())
+			continue
+		}
+
+		var deps []string
+		if m := vendoredModule(args.Config, b, dep); m != nil {
+			_, modules, err := l.scanModule(args.Config, b, *m)
+			if err != nil {
+				log.Printf("%s: %v", m.Dir, err)
+			}
+			for _, m := range modules {
+				deps = append(deps, fmt.Sprintf("@%s//:%s", repoName(m.Name), fileinfo.RuleName(m.Name, libraryRulePrefix)))
+			}
+		}
+
+		r := newRepositoryRule(args.Config, dep, deps)
+		names[r.Name()] = true
+		res.Gen = append(res.Gen, r)
+	}
+
+	if args.Prune {
+		for _, r := range args.Config.Repos {
+			if isBundlerRepository(r) && !names[r.Name()] {
+				res.Empty = append(res.Empty, rule.NewRule(gitRepositoryRule, r.Name()))
+			}
+		}
+	}
+	return res
+}
+
+// newRepositoryRule returns the new_git_repository rule of a dependency
+func newRepositoryRule(c *config.Config, dep bundler.Dependency, deps []string) *rule.Rule {
+	name := dep.LegacyName()
+	r := rule.NewRule(gitRepositoryRule, repoName(name))
+	r.SetAttr("remote", dep.Source.Git.Remote)
+	r.SetAttr("commit", dep.Version)
+	if dep.Source.Git.Subdir != "" {
+		r.SetAttr("strip_prefix", dep.Source.Git.Subdir)
+	}
+
+	conf := GetConfig(c)
+	var patterns []string
+	for ext := range conf.NativeImports {
+		patterns = append(patterns, "**/*"+ext)
+	}
+	patterns = append(patterns, "**/*.json")
+	sort.Strings(patterns)
+
+	overlay := rule.EmptyFile("", "")
+	lib := rule.NewRule(libraryRule, fileinfo.RuleName(name, libraryRulePrefix))
+	lib.SetAttr("srcs", rule.GlobValue{Patterns: patterns})
+	// The parent directory of an external repository contains all of them
+	lib.SetAttr("imports", []string{".."})
+	if len(deps) > 0 {
+		lib.SetAttr("deps", deps)
+	}
+	lib.SetAttr("visibility", []string{"//visibility:public"})
+	repo := conf.RulesRepo
+	if repo == "" {
+		// update-repos does not configure the root directory
+		repo = rulesRepo(c.RepoRoot, loadRootFile(c.RepoRoot))
+	}
+	load := rule.NewLoad(rulesLoad(repo))
+	load.Add(libraryRule)
+	load.Insert(overlay, 0)
+	overlay.Sync()
+	lib.Insert(overlay)
+	r.SetAttr("build_file_content", &bzl.StringExpr{Value: string(overlay.Format()), TripleQuote: true})
+
+	return r
+}
+
+// isBundlerRepository returns whether a repository rule was imported from a
+// jsonnet-bundler lock file.
+func isBundlerRepository(r *rule.Rule) bool {
+	return r.Kind() == gitRepositoryRule && strings.Contains(r.AttrString("build_file_content"), libraryRule)
+}
+
+// loadVendoredBundle returns the Bundle of the directory of a lock file, if it
+// belongs to the workspace.
+func loadVendoredBundle(c *config.Config, lockFile string) *bundler.Bundle {
+	abs, err := filepath.Abs(lockFile)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(c.RepoRoot, filepath.Dir(abs))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	if rel == "." {
+		rel = ""
+	}
+	b, err := bundler.Load(c.RepoRoot, filepath.ToSlash(rel))
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return b
+}
+
+// vendoredModule returns the module of a dependency if it is vendored
+func vendoredModule(c *config.Config, b *bundler.Bundle, dep bundler.Dependency) *bundler.Module {
+	if b == nil {
+		return nil
+	}
+	for i, m := range b.Modules {
+		if m.ImportPath == dep.ImportPath() && exists(filepath.Join(c.RepoRoot, m.Dir)) {
+			return &b.Modules[i]
+		}
+	}
+	return nil
+}
+
+// repoName returns a valid repository name for a module name
+func repoName(name string) string {
+	return repoNameRe.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+func TestImportRepos(t *testing.T) {
+	lock := `{
+  "version": 1,
+  "dependencies": [
+    {
+      "source": {"git": {"remote": "https://github.com/grafana/grafonnet-lib.git", "subdir": "grafonnet"}},
+      "version": "3626fc4dee2ca8ca6bd2b8b9b9c48b4e3e0fc4b0"
+    },
+    {
+      "source": {"git": {"remote": "https://github.com/kubernetes-monitoring/kubernetes-mixin.git", "subdir": ""}},
+      "version": "b61c5a34051f8f57284a08fe78ad8a45b430252b"
+    },
+    {
+      "source": {"local": {"directory": "shared"}},
+      "version": ""
+    }
+  ]
+}`
+	root := writeWorkspace(t, map[string]string{
+		"jsonnetfile.lock.json": lock,
+		"vendor/github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet":          "{}",
+		"vendor/github.com/kubernetes-monitoring/kubernetes-mixin/mixin.libsonnet":     "import 'grafonnet/grafana.libsonnet'",
+		"vendor/github.com/kubernetes-monitoring/kubernetes-mixin/lib/utils.libsonnet": "{}",
+	})
+	defer os.RemoveAll(root)
+	if err := os.Symlink("github.com/grafana/grafonnet-lib/grafonnet", filepath.Join(root, "vendor/grafonnet")); err != nil {
+		t.Fatal(err)
+	}
+
+	importer := jsonnet.NewLanguage().(language.RepoImporter)
+	path := filepath.Join(root, "jsonnetfile.lock.json")
+	if !importer.CanImport(path) {
+		t.Fatalf("cannot import %s", path)
+	}
+	c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}}
+	c.Repos = []*rule.Rule{rule.NewRule("new_git_repository", "ksonnet")}
+	c.Repos[0].SetAttr("build_file_content", `jsonnet_library(name = "ksonnet_library")`)
+	res := importer.ImportRepos(language.ImportReposArgs{Config: c, Path: path, Prune: true})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	want := []struct {
+		name, remote, commit, stripPrefix string
+		overlay                           []string
+	}{
+		{
+			name:        "grafonnet",
+			remote:      "https://github.com/grafana/grafonnet-lib.git",
+			commit:      "3626fc4dee2ca8ca6bd2b8b9b9c48b4e3e0fc4b0",
+			stripPrefix: "grafonnet",
+			overlay:     []string{`name = "grafonnet_library"`, `imports = [".."]`},
+		}, {
+			name:    "kubernetes-mixin",
+			remote:  "https://github.com/kubernetes-monitoring/kubernetes-mixin.git",
+			commit:  "b61c5a34051f8f57284a08fe78ad8a45b430252b",
+			overlay: []string{`name = "kubernetes_mixin_library"`, `deps = ["@grafonnet//:grafonnet_library"]`},
+		},
+	}
+	if len(res.Gen) != len(want) {
+		t.Fatalf("got %d rules; want %d", len(res.Gen), len(want))
+	}
+	for i, r := range res.Gen {
+		w := want[i]
+		if r.Kind() != "new_git_repository" || r.Name() != w.name {
+			t.Errorf("got %s %q; want new_git_repository %q", r.Kind(), r.Name(), w.name)
+		}
+		if got := r.AttrString("remote"); got != w.remote {
+			t.Errorf("%s: remote: got %q; want %q", w.name, got, w.remote)
+		}
+		if got := r.AttrString("commit"); got != w.commit {
+			t.Errorf("%s: commit: got %q; want %q", w.name, got, w.commit)
+		}
+		if got := r.AttrString("strip_prefix"); got != w.stripPrefix {
+			t.Errorf("%s: strip_prefix: got %q; want %q", w.name, got, w.stripPrefix)
+		}
+		overlay := r.AttrString("build_file_content")
+		for _, s := range w.overlay {
+			if !strings.Contains(overlay, s) {
+				t.Errorf("%s: build_file_content: got %s; want it to contain %s", w.name, overlay, s)
+			}
+		}
+	}
+
+	if len(res.Empty) != 1 || res.Empty[0].Name() != "ksonnet" {
+		t.Errorf("got empty rules %v; want ksonnet", res.Empty)
+	}
+}
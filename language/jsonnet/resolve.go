@@ -4,6 +4,12 @@
 package jsonnet
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -11,7 +17,9 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/graph"
 )
 
 // Implement the Resolver interface
@@ -42,75 +50,354 @@ func (*Lang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.Imp
 	//
 	// Therefore, we want to identify each rule by its pkg inside the workspace.
 
-	return []resolve.ImportSpec{
+	specs := []resolve.ImportSpec{
 		resolve.ImportSpec{Lang: "any", Imp: f.Pkg},
 	}
+
+	// Libraries are also identified by their sources, so imports of generated
+	// files can be resolved to the library wrapping them.
+	if r.Kind() == libraryRule {
+		for _, src := range r.AttrStrings("srcs") {
+			if l, err := label.Parse(src); err == nil && l.Repo == "" && (l.Relative || l.Pkg == f.Pkg) {
+				specs = append(specs, resolve.ImportSpec{Lang: languageName, Imp: path.Join(f.Pkg, l.Name)})
+			}
+		}
+	}
+	return specs
 }
 func (*Lang) Name() string { return languageName }
-func (*Lang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+func (l *Lang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	if l.cycles == nil {
+		l.visited(c, ix)
+	}
 	if imports == nil {
 		return
 	}
 
-	var resolveFunc func(ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
+	var resolveFunc func(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
 	switch r.Kind() {
 	case libraryRule:
-		resolveFunc = resolveLibraryRule
-	case toJSONRule:
-		resolveFunc = resolveToJSONRule
+		resolveFunc = l.resolveLibraryRule
+	case toJSONRule, toJSONTestRule:
+		resolveFunc = l.resolveToJSONRule
 	}
 
 	if resolveFunc != nil {
-		resolveFunc(ix, rc, r, imports, from)
+		resolveFunc(c, ix, rc, r, imports, from)
 	}
 }
 
-func resolveLibraryRule(ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+func (l *Lang) resolveLibraryRule(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	conf := GetConfig(c)
+	jsonnetImports := imports.(map[string]fileinfo.FilePath)
+	dataImports := r.PrivateAttr(dataImpPrivateAttr).(map[string]fileinfo.FilePath)
+	exprs := importExprs{}
+	exprs.positions, _ = r.PrivateAttr(jsonnetPosPrivateAttr).(map[string]fileinfo.Position)
+	exprs.kinds, _ = r.PrivateAttr(jsonnetKindPrivateAttr).(map[string]fileinfo.ImportKind)
+	exprs.strs, _ = r.PrivateAttr(jsonnetStrPrivateAttr).(map[string]string)
+	missing, _ := r.PrivateAttr(jsonnetMisPrivateAttr).(map[string]bool)
+
+	// Bazel rejects cyclic deps. The files of an import cycle within a package
+	// can be merged into the library of the first file of the cycle, which the
+	// libraries of the other files re-export.
+	for _, self := range r.PrivateAttr(jsonnetSelfPrivateAttr).(map[string]fileinfo.FilePath) {
+		cycle := l.cycles[self.Path]
+		if cycle == nil || !conf.MergeCycles {
+			continue
+		}
+		if !isSamePackage(cycle.Files) {
+			if self.Path == cycle.Files[0] {
+				log.Printf("%s: cannot merge import cycle: its files belong to different packages", cycle.Chain[0].Pos)
+			}
+			continue
+		}
+		if self.Path != cycle.Files[0] {
+			first, _ := l.graph.File(cycle.Files[0])
+			setMergedAttr(r, "srcs", nil, from.Pkg, false)
+			setMergedAttr(r, "deps", []string{":" + first.Path.RuleName(libraryRulePrefix)}, from.Pkg, false)
+			return
+		}
+		srcs := []string{self.Filename}
+		jsonnetImports, dataImports = map[string]fileinfo.FilePath{}, map[string]fileinfo.FilePath{}
+		exprs, missing = newImportExprs(), map[string]bool{}
+		for _, path := range cycle.Files {
+			finfo, _ := l.graph.File(path)
+			if path != self.Path {
+				srcs = append(srcs, finfo.Path.Filename)
+			}
+			for imp, fpath := range finfo.Imports {
+				jsonnetImports[imp] = fpath
+			}
+			for imp, fpath := range finfo.DataImports {
+				dataImports[imp] = fpath
+			}
+			for imp, pos := range finfo.Positions {
+				exprs.positions[imp] = pos
+			}
+			for imp, kind := range finfo.Kinds {
+				exprs.kinds[imp] = kind
+			}
+			for imp, str := range finfo.ImportStrs {
+				exprs.strs[imp] = str
+			}
+			for imp := range finfo.Missing {
+				missing[imp] = true
+			}
+		}
+		for _, path := range cycle.Files {
+			delete(jsonnetImports, path)
+		}
+		// Leave self-import at the top
+		sort.Strings(srcs[1:])
+		r.SetAttr("srcs", srcs)
+	}
+
 	// Data imports will be added either as labels or refs, depending on whether its
 	// directory is also a pkg or not.
 	srcs := []string{}
-	for _, fpath := range r.PrivateAttr(dataImpPrivateAttr).(map[string]fileinfo.FilePath) {
-		// If the rules index responds to the relative directory of the data dependency,
-		// it means that there is at least a rule belonging to a BUILD file in that
-		// directory. In that case, we should refer to the data dependency by its
-		// label.
-		spec := resolve.ImportSpec{Lang: "any", Imp: fpath.Package}
-		if matches := ix.FindRulesByImport(spec, "jsonnet"); len(matches) > 0 {
+	// Libraries of vendored modules and external repositories, which may
+	// provide several imports
+	libraries := map[string]bool{}
+	for _, fpath := range dataImports {
+		if l.isMissing(fpath, missing, exprs) && conf.ShouldDropMissingImports() {
+			continue
+		}
+		// Generated files are referred to by the label of the output of their rule
+		if l.genFiles[fpath.Path] {
 			srcs = append(srcs, fpath.NewDataLabel())
 			continue
 		}
-		// Otherwise, we can refer to it by a plain ref.
-		srcs = append(srcs, fpath.NewDataRef())
+		// Vendored files are srcs of the library of their module
+		if m := conf.Bundle.Module(fpath.Path); m != nil {
+			libraries[newModuleLabel(conf.Bundle, *m).String()] = true
+			continue
+		}
+		srcs = append(srcs, newDataLabel(ix, fpath))
 	}
-
-	if len(srcs) > 0 {
-		sort.Strings(srcs)
-		// Leave self-import at the top
-		r.SetAttr("srcs", append(r.AttrStrings("srcs"), srcs...))
+	externalData, _ := r.PrivateAttr(externalDataPrivateAttr).(map[string]label.Label)
+	for _, lbl := range externalData {
+		srcs = append(srcs, lbl.String())
 	}
 
+	// Data imports follow the sources the rule was generated with
+	sort.Strings(srcs)
+	setMergedAttr(r, "srcs", srcs, from.Pkg, true)
+
 	// Jsonnet imports will be added as labels, as they will certainly be part of a pkg
 	deps := []string{}
-	for _, fpath := range imports.(map[string]fileinfo.FilePath) {
+	for _, fpath := range jsonnetImports {
+		spec := resolve.ImportSpec{Lang: "any", Imp: fpath.Package}
+		isMissing := l.isMissing(fpath, missing, exprs)
+		if isMissing && conf.ShouldDropMissingImports() {
+			continue
+		}
+		if m := conf.Bundle.Module(fpath.Path); m != nil {
+			libraries[newModuleLabel(conf.Bundle, *m).String()] = true
+			continue
+		}
+		if l.genFiles[fpath.Path] {
+			deps = append(deps, l.resolveGenerated(ix, fpath, from).String())
+			continue
+		}
+		if !isMissing && c.IndexLibraries && len(ix.FindRulesByImport(spec, languageName)) == 0 {
+			// Unresolvable imports can only be told apart when libraries are indexed.
+			log.Print(exprs.importError(fpath, fmt.Errorf("no jsonnet rules found in package %q", fpath.Package)))
+		}
 		deps = append(deps, fpath.NewLabel(libraryRulePrefix).String())
 	}
-
-	r.DelAttr("deps")
-	if len(deps) > 0 {
-		sort.Strings(deps)
-		r.SetAttr("deps", deps)
+	external, _ := r.PrivateAttr(externalPrivateAttr).(map[string]label.Label)
+	for _, lbl := range external {
+		libraries[lbl.String()] = true
 	}
+	deps = append(deps, sortedKeys(libraries)...)
+
+	sort.Strings(deps)
+	setMergedAttr(r, "deps", deps, from.Pkg, false)
 }
 
-func resolveToJSONRule(ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+func (l *Lang) resolveToJSONRule(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
 	deps := []string{}
 	for _, fpath := range imports.(map[string]fileinfo.FilePath) {
 		deps = append(deps, fpath.NewLabel(libraryRulePrefix).String())
 	}
 
-	r.DelAttr("deps")
-	if len(deps) > 0 {
-		sort.Strings(deps)
-		r.SetAttr("deps", deps)
+	sort.Strings(deps)
+	setMergedAttr(r, "deps", deps, from.Pkg, false)
+}
+
+// setMergedAttr sets a list attribute of a rule to the given values, merged with
+// the values the rule holds already. Values annotated with a "# keep" comment are
+// preserved along with their comments, and so are the others if keepAll is set,
+// e.g. the srcs set when the rule was generated. Other values are replaced.
+//
+// Values referring to the same file or target, e.g. "a.json" and "//pkg:a.json"
+// in package pkg, are only set once, preferring the ones to keep. The attribute
+// is deleted if it ends up empty.
+func setMergedAttr(r *rule.Rule, key string, values []string, pkg string, keepAll bool) {
+	var list []bzl.Expr
+	seen := map[string]bool{}
+	add := func(value string, e bzl.Expr) {
+		if k := labelKey(value, pkg); !seen[k] {
+			seen[k] = true
+			list = append(list, e)
+		}
+	}
+
+	if old, ok := r.Attr(key).(*bzl.ListExpr); ok {
+		for _, e := range old.List {
+			if str, ok := e.(*bzl.StringExpr); ok && rule.ShouldKeep(e) {
+				add(str.Value, e)
+			}
+		}
+		for _, e := range old.List {
+			if str, ok := e.(*bzl.StringExpr); ok && keepAll {
+				add(str.Value, e)
+			}
+		}
+	}
+	for _, value := range values {
+		add(value, &bzl.StringExpr{Value: value})
+	}
+
+	if len(list) == 0 {
+		r.DelAttr(key)
+		return
+	}
+	r.SetAttr(key, &bzl.ListExpr{List: list, ForceMultiLine: len(list) > 1})
+}
+
+// labelKey returns the path of the file or target a value of a label list refers
+// to, relative to the root of the workspace, so values can be compared.
+func labelKey(value, pkg string) string {
+	l, err := label.Parse(value)
+	if err != nil || l.Repo != "" {
+		return value
+	}
+	if l.Relative {
+		l.Pkg = pkg
+	}
+	return path.Join(l.Pkg, l.Name)
+}
+
+// resolveGenerated returns the label of the library wrapping a generated file.
+// It is either an existing library whose sources include the file, or the one
+// generated by newGeneratedLibraryRule.
+func (l *Lang) resolveGenerated(ix *resolve.RuleIndex, fpath fileinfo.FilePath, from label.Label) label.Label {
+	spec := resolve.ImportSpec{Lang: languageName, Imp: fpath.Path}
+	for _, match := range ix.FindRulesByImport(spec, languageName) {
+		if !match.Label.Equal(from) {
+			return match.Label
+		}
+	}
+	return fpath.NewLabel(libraryRulePrefix)
+}
+
+// isMissing returns whether the target of an import neither exists in the
+// filesystem nor is generated by any rule. Missing imports are reported
+// at the position of their import expression.
+func (l *Lang) isMissing(fpath fileinfo.FilePath, missing map[string]bool, exprs importExprs) bool {
+	if !missing[fpath.Path] || l.genFiles[fpath.Path] {
+		return false
+	}
+	log.Print(exprs.importError(fpath, errors.New("no such file in the workspace, nor generated by any rule")))
+	return true
+}
+
+// importExprs holds the positions, kinds and strings of the import expressions
+// of a rule, keyed by import path.
+type importExprs struct {
+	positions map[string]fileinfo.Position
+	kinds     map[string]fileinfo.ImportKind
+	strs      map[string]string
+}
+
+func newImportExprs() importExprs {
+	return importExprs{
+		positions: map[string]fileinfo.Position{},
+		kinds:     map[string]fileinfo.ImportKind{},
+		strs:      map[string]string{},
+	}
+}
+
+// importError returns an error located at the import expression of a file, which
+// is reported as written. Imports without recorded string are reported by path.
+func (e importExprs) importError(fpath fileinfo.FilePath, err error) ImportError {
+	str, found := e.strs[fpath.Path]
+	if !found {
+		str = fpath.Path
+	}
+	return ImportError{Pos: e.positions[fpath.Path], Kind: e.kinds[fpath.Path], Import: str, Err: err}
+}
+
+// visited is called on the first call to Resolve, once every package has been
+// visited and indexed. It reports import cycles and writes the import graph.
+func (l *Lang) visited(c *config.Config, ix *resolve.RuleIndex) {
+	l.cycles = make(map[string]*graph.Cycle)
+	for _, cycle := range l.graph.Cycles() {
+		cycle := cycle
+		log.Print(cycle)
+		for _, file := range cycle.Files {
+			l.cycles[file] = &cycle
+		}
+	}
+
+	if conf := GetConfig(c); conf.GraphOut != "" {
+		if err := l.writeGraph(conf, ix); err != nil {
+			log.Printf("error writing import graph: %v", err)
+		}
+	}
+}
+
+// writeGraph writes the import graph where the -jsonnet_graph_out flag states.
+// Generated and vendored files are left out of its target level, as they are not
+// provided by targets named after them, see resolveLibraryRule.
+func (l *Lang) writeGraph(conf *Config, ix *resolve.RuleIndex) error {
+	export := l.graph.Export(func(fpath fileinfo.FilePath, kind fileinfo.ImportKind) string {
+		if l.genFiles[fpath.Path] || conf.Bundle.Module(fpath.Path) != nil {
+			return ""
+		}
+		if kind == fileinfo.Import && conf.IsNativeImport(fpath.Ext) {
+			return fpath.NewLabel(libraryRulePrefix).String()
+		}
+		return newDataLabel(ix, fpath)
+	})
+
+	f, err := os.Create(conf.GraphOut)
+	if err != nil {
+		return err
+	}
+	if conf.GraphFormat == graphFormatDOT {
+		err = export.WriteDOT(f)
+	} else {
+		err = export.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// newDataLabel returns either a label or a ref for a data import, depending on
+// whether its directory is also a pkg or not.
+func newDataLabel(ix *resolve.RuleIndex, fpath fileinfo.FilePath) string {
+	// If the rules index responds to the relative directory of the data dependency,
+	// it means that there is at least a rule belonging to a BUILD file in that
+	// directory. In that case, we should refer to the data dependency by its
+	// label.
+	spec := resolve.ImportSpec{Lang: "any", Imp: fpath.Package}
+	if matches := ix.FindRulesByImport(spec, "jsonnet"); len(matches) > 0 {
+		return fpath.NewDataLabel()
+	}
+	// Otherwise, we can refer to it by a plain ref.
+	return fpath.NewDataRef()
+}
+
+// isSamePackage returns whether all the given files belong to the same package
+func isSamePackage(paths []string) bool {
+	for _, path := range paths[1:] {
+		if filepath.Dir(path) != filepath.Dir(paths[0]) {
+			return false
+		}
 	}
+	return true
 }
@@ -0,0 +1,355 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet"
+)
+
+// update runs the generation, merge and resolution phases of Gazelle on a
+// package of a workspace, and returns its build file.
+func update(t *testing.T, root, rel, build string) *rule.File {
+	t.Helper()
+	lang := jsonnet.NewLanguage()
+	c := &config.Config{RepoRoot: root, Exts: map[string]interface{}{}, IndexLibraries: true}
+	lang.Configure(c, "", nil)
+
+	path := filepath.Join(root, rel, "BUILD.bazel")
+	f, err := rule.LoadData(path, rel, []byte(build))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Configure(c, rel, f)
+
+	infos, err := ioutil.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() && info.Name() != "BUILD.bazel" {
+			files = append(files, info.Name())
+		}
+	}
+	// Files generated by the rules of the build file, as Gazelle finds them
+	var genFiles []string
+	for _, r := range f.Rules {
+		genFiles = append(genFiles, r.AttrStrings("outs")...)
+	}
+	res := lang.GenerateRules(language.GenerateArgs{
+		Config:       c,
+		Dir:          filepath.Join(root, rel),
+		Rel:          rel,
+		File:         f,
+		RegularFiles: files,
+		GenFiles:     genFiles,
+	})
+
+	kinds := lang.Kinds()
+	merger.MergeFile(f, res.Empty, res.Gen, merger.PreResolve, kinds)
+	ix := resolve.NewRuleIndex(func(*rule.Rule, string) resolve.Resolver { return lang })
+	for _, r := range f.Rules {
+		ix.AddRule(c, r, f)
+	}
+	ix.Finish()
+	for i, r := range res.Gen {
+		lang.Resolve(c, ix, nil, r, res.Imports[i], label.New("", rel, r.Name()))
+	}
+	merger.MergeFile(f, res.Empty, res.Gen, merger.PostResolve, kinds)
+	return f
+}
+
+// writeWorkspace writes the given files, keyed by workspace-relative path, in a
+// new directory, and returns its path.
+func writeWorkspace(t *testing.T, files map[string]string) (root string) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestResolveMerge(t *testing.T) {
+	testCases := []struct {
+		desc, old, want string
+	}{
+		{
+			desc: "new",
+			want: `jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "main.jsonnet",
+        "//app:data.json",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//app:lib_library"],
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		}, {
+			desc: "keep",
+			old: `jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "main.jsonnet",
+        "extra.txt",  # keep
+    ],
+    deps = [
+        "//app:old_library",
+        "//tools:helper",  # keep
+    ],
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    deps = [
+        "//app:main_library",
+        "//tools:data",  # keep
+    ],
+)
+`,
+			want: `jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "extra.txt",  # keep
+        "main.jsonnet",
+        "//app:data.json",
+    ],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//app:lib_library",
+        "//tools:helper",  # keep
+    ],
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//app:main_library",
+        "//tools:data",  # keep
+    ],
+)
+`,
+		}, {
+			desc: "duplicates",
+			old: `jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "main.jsonnet",
+        ":data.json",  # keep
+        "//app:main.jsonnet",  # keep
+    ],
+    deps = [":lib_library"],  # keep
+)
+`,
+			want: `jsonnet_library(
+    name = "main_library",
+    srcs = [
+        ":data.json",  # keep
+        "//app:main.jsonnet",  # keep
+    ],
+    visibility = ["//visibility:public"],
+    deps = [":lib_library"],  # keep
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		}, {
+			desc: "keep rule",
+			old: `# keep
+jsonnet_library(
+    name = "main_library",
+    srcs = ["main.jsonnet"],
+    deps = ["//tools:helper"],
+)
+`,
+			want: `# keep
+jsonnet_library(
+    name = "main_library",
+    srcs = ["main.jsonnet"],
+    deps = ["//tools:helper"],
+)
+
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":  `(import 'lib.libsonnet') + { data: importstr 'data.json' }`,
+		"app/lib.libsonnet": "{}",
+		"app/data.json":     "{}",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.old)
+			// Only compare the rules of main.jsonnet
+			for _, r := range f.Rules {
+				if !strings.HasPrefix(r.Name(), "main_") {
+					r.Delete()
+				}
+			}
+			if got := string(f.Format()); strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveMissingImports(t *testing.T) {
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": "{\n  lib: import './missing.libsonnet',\n  data: importstr '../data/missing.json',\n}",
+	})
+	defer os.RemoveAll(root)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	update(t, root, "app", "")
+
+	// Missing imports are reported as written, at their import expression
+	for _, want := range []string{
+		`app/main.jsonnet:2:8: import "./missing.libsonnet": no such file`,
+		`app/main.jsonnet:3:9: importstr "../data/missing.json": no such file`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("got logs:\n%s\nwant %q", logs.String(), want)
+		}
+	}
+}
+
+func TestResolveGenFiles(t *testing.T) {
+	testCases := []struct {
+		desc, build string
+		want        string
+	}{
+		{
+			desc: "wrapper",
+			build: `genrule(
+    name = "gen",
+    outs = [
+        "crds.libsonnet",
+        "data.json",
+    ],
+)
+`,
+			want: `jsonnet_library(
+    name = "crds_library",
+    srcs = ["crds.libsonnet"],
+    visibility = ["//visibility:public"],
+)
+
+jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "main.jsonnet",
+        "//app:data.json",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//app:crds_library"],
+)
+`,
+		}, {
+			desc: "existing library",
+			build: `genrule(
+    name = "gen",
+    outs = [
+        "crds.libsonnet",
+        "data.json",
+    ],
+)
+
+jsonnet_library(
+    name = "crds",
+    srcs = ["//app:crds.libsonnet"],
+)
+`,
+			want: `jsonnet_library(
+    name = "crds",
+    srcs = ["//app:crds.libsonnet"],
+)
+
+jsonnet_library(
+    name = "main_library",
+    srcs = [
+        "main.jsonnet",
+        "//app:data.json",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//app:crds"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": `(import 'crds.libsonnet') + { data: importstr 'data.json' }`,
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.build)
+			// Only compare the libraries
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_library" {
+					r.Delete()
+				}
+			}
+			if got := string(f.Format()); strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
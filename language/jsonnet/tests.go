@@ -0,0 +1,249 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	// testsOff generates no jsonnet_to_json_test rule
+	testsOff = "off"
+	// errorFileSuffix ends the names of the files expected to fail, e.g. main_error.jsonnet
+	errorFileSuffix = "_error"
+)
+
+var (
+	// goldenSuffixes are the default suffixes of the golden files of jsonnet files,
+	// e.g. main.golden.json for main.jsonnet
+	goldenSuffixes = []string{".golden.json", ".golden.yaml", "_test.golden.json", "_test.golden.yaml"}
+	// errorSuffixes are the default suffixes of the expected errors of the jsonnet
+	// files expected to fail, e.g. main_error.txt for main_error.jsonnet
+	errorSuffixes = []string{".txt", ".golden.txt"}
+)
+
+// parseSuffixes parses a comma separated list of file suffixes, or off.
+func parseSuffixes(value string) ([]string, error) {
+	if value == testsOff {
+		return []string{}, nil
+	}
+	var suffixes []string
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.Contains(s, "/") {
+			return nil, fmt.Errorf("got %q: want <suffix>,... or %s", value, testsOff)
+		}
+		suffixes = append(suffixes, s)
+	}
+	return suffixes, nil
+}
+
+// setGoldenSuffixes sets the suffixes of golden files from a jsonnet_golden_tests
+// value, e.g. ".golden.json,.golden.yaml", or off.
+func (conf *Config) setGoldenSuffixes(value string) (err error) {
+	conf.GoldenSuffixes, err = parseSuffixes(value)
+	return err
+}
+
+// setErrorSuffixes sets the suffixes of expected errors from a jsonnet_error_tests
+// value, e.g. ".txt,.golden.txt", or off.
+func (conf *Config) setErrorSuffixes(value string) (err error) {
+	conf.ErrorSuffixes, err = parseSuffixes(value)
+	return err
+}
+
+// isErrorFile returns whether a file is expected to fail, i.e. it is named
+// *_error and has an expected error.
+func isErrorFile(conf *Config, finfo fileinfo.FileInfo, files map[string]bool) bool {
+	return strings.HasSuffix(finfo.Path.Name, errorFileSuffix) && findSibling(finfo.Path.Name, conf.ErrorSuffixes, files) != ""
+}
+
+// findSibling returns the first file named after a base name and one of the suffixes, if any
+func findSibling(base string, suffixes []string, files map[string]bool) string {
+	for _, suffix := range suffixes {
+		if files[base+suffix] {
+			return base + suffix
+		}
+	}
+	return ""
+}
+
+// testRuleName returns the name of the jsonnet_to_json_test rule of a variant,
+// e.g. main_test or main_prod_test.
+func testRuleName(fpath fileinfo.FilePath, variant string) string {
+	if variant == "" {
+		return fpath.RuleName(testRulePrefix)
+	}
+	return fileinfo.RuleName(fpath.Name+"_"+variant, testRulePrefix)
+}
+
+// newTestRules returns the jsonnet_to_json_test rules of a file: a test comparing
+// its output with its golden file, e.g. main.golden.json, or the golden file of each
+// variant, e.g. main.prod.golden.json, and a test expecting files named *_error to
+// fail with their expected error, e.g. main_error.txt. The tests of the file that
+// are no longer generated, e.g. after their golden file was removed, are returned
+// as empty rules, so they are deleted.
+//
+// Tests are given the external variables and top-level arguments of the
+// jsonnet_to_json rules. Variables without value are reported for them already,
+// but for the files expected to fail, which have none, see setExtVarAttrs.
+func (l *Lang) newTestRules(args language.GenerateArgs, conf *Config, finfo fileinfo.FileInfo, files map[string]bool) (gen, empty []*rule.Rule) {
+	vars := l.extVars(args.Config, finfo)
+	if !isErrorFile(conf, finfo, files) {
+		for name := range vars {
+			if _, found := conf.ExtVars[name]; !found {
+				delete(vars, name)
+			}
+		}
+	}
+	variants := []string{""}
+	if conf.Variants != nil {
+		if _, found := vars[conf.Variants.Var]; found {
+			variants = conf.Variants.Values
+		}
+	}
+
+	names := map[string]bool{}
+	add := func(vconf *Config, r *rule.Rule) {
+		setExtVarAttrs(vconf, r, finfo.Path, vars)
+		setTLAAttrs(vconf, r, finfo.Function)
+		syncAttrs(args.File, r, append(extVarAttrs, tlaAttrs...)...)
+		gen = append(gen, r)
+		names[r.Name()] = true
+	}
+
+	switch {
+	case finfo.Function != nil && len(missingTLAs(conf, finfo.Function)) > 0:
+		// Functions cannot be evaluated without their top-level arguments
+	case isErrorFile(conf, finfo, files):
+		r := newTestRule(finfo, "", findSibling(finfo.Path.Name, conf.ErrorSuffixes, files))
+		r.SetAttr("error", 1)
+		add(conf, r)
+	case len(conf.MultipleOutputs(finfo)) > 0:
+		// Tests compare a single output with their golden file
+		for _, variant := range variants {
+			if golden := findGolden(conf, finfo, variant, files); golden != "" {
+				log.Printf("%s: no %s is generated for %s: it writes multiple outputs", path.Join(finfo.Path.Package, golden), toJSONTestRule, finfo.Path.Filename)
+			}
+		}
+	default:
+		for _, variant := range variants {
+			golden := findGolden(conf, finfo, variant, files)
+			if golden == "" {
+				continue
+			}
+			vconf := conf
+			if variant != "" {
+				vconf = conf.forVariant(variant)
+			}
+			r := newTestRule(finfo, variant, golden)
+			if path.Ext(golden) == ".yaml" || path.Ext(golden) == ".yml" {
+				r.SetAttr("yaml_stream", true)
+			}
+			add(vconf, r)
+		}
+	}
+
+	if args.File != nil {
+		for _, r := range args.File.Rules {
+			if isGeneratedTest(r, finfo.Path) && !names[r.Name()] {
+				empty = append(empty, rule.NewRule(toJSONTestRule, r.Name()))
+			}
+		}
+	}
+	return gen, empty
+}
+
+// newUnparsedTestRules returns the jsonnet_to_json_test rule of a file that cannot
+// be parsed, if it is expected to fail: it is named *_error and has an expected
+// error. Its imports, external variables and top-level arguments are unknown, so
+// the test has no deps and passes none. Its jsonnet_to_json rule is returned as an
+// empty rule, as the ones of the other files expected to fail, and so is its
+// jsonnet_library, as it has no imports to provide.
+func newUnparsedTestRules(args language.GenerateArgs, conf *Config, name string, files map[string]bool) (gen, empty []*rule.Rule) {
+	fpath, err := fileinfo.NewFilePath(args.Config.RepoRoot, args.Rel, name)
+	if err != nil || !isErrorFile(conf, fileinfo.FileInfo{Path: fpath}, files) {
+		return nil, nil
+	}
+	r := rule.NewRule(toJSONTestRule, testRuleName(fpath, ""))
+	r.SetAttr("src", fpath.Filename)
+	r.SetAttr("golden", findSibling(fpath.Name, conf.ErrorSuffixes, files))
+	r.SetAttr("error", 1)
+	empty = []*rule.Rule{
+		rule.NewRule(libraryRule, fpath.RuleName(libraryRulePrefix)),
+		rule.NewRule(toJSONRule, fpath.RuleName(toJSONRulePrefix)),
+	}
+	return []*rule.Rule{r}, empty
+}
+
+// isGeneratedTest returns whether a jsonnet_to_json_test rule of a build file tests
+// a file against a file named after it, as the generated tests do, e.g. main_test
+// and main.golden.json, or main_prod_test and main.prod.golden.json. Other tests of
+// the file are left alone.
+func isGeneratedTest(r *rule.Rule, fpath fileinfo.FilePath) bool {
+	golden := r.AttrString("golden")
+	if r.Kind() != toJSONTestRule || r.AttrString("src") != fpath.Filename || !strings.HasPrefix(golden, fpath.Name) {
+		return false
+	}
+	if r.Name() == testRuleName(fpath, "") {
+		return true
+	}
+	variant := strings.TrimPrefix(golden, fpath.Name+".")
+	if i := strings.Index(variant, "."); i > 0 && variant != golden {
+		return r.Name() == testRuleName(fpath, variant[:i])
+	}
+	return false
+}
+
+// findGolden returns the golden file of a variant of a file, if any, e.g.
+// main.golden.json or main.prod.golden.json. Files expected to fail have none.
+func findGolden(conf *Config, finfo fileinfo.FileInfo, variant string, files map[string]bool) string {
+	if strings.HasSuffix(finfo.Path.Name, errorFileSuffix) {
+		return ""
+	}
+	base := finfo.Path.Name
+	if variant != "" {
+		base += "." + variant
+	}
+	return findSibling(base, conf.GoldenSuffixes, files)
+}
+
+// https://github.com/bazelbuild/rules_jsonnet#user-content-jsonnet_to_json_test
+//
+// jsonnet_to_json_test
+//
+// This rule implementation will only generate rules with:
+//
+// name:    [required]  A unique name for this rule.
+// src:     [required]  The .jsonnet file to convert to JSON.
+// golden:  <optional>  The expected (combined stdout and stderr) output to compare to the output of running jsonnet on src.
+// deps:    <optional>  List of targets that are required by the src Jsonnet file.
+//
+// error:       <optional>  Default: 0. The expected error code from running jsonnet on src. Set to 1 for the files named *_error.
+// yaml_stream: <optional>  Default: False. Set to 1 to write output as a YAML stream of JSON documents. Set for the golden files with a .yaml extension.
+//
+// The external variables and top-level arguments are set as in jsonnet_to_json.
+//
+// This rule implementation will not generate (yet) rules with:
+//
+// regex:               <optional>  Set to 1 if golden contains a regex used to match the output of running jsonnet on src.
+// canonicalize_golden: <optional>  Default: True. If set to False, will not canonicalize the golden file.
+// imports:             <optional>  List of import -J flags to be passed to the jsonnet compiler.
+func newTestRule(finfo fileinfo.FileInfo, variant, golden string) *rule.Rule {
+	r := rule.NewRule(toJSONTestRule, testRuleName(finfo.Path, variant))
+	r.SetAttr("src", finfo.Path.Filename)
+	r.SetAttr("golden", golden)
+
+	// Tests depend on the jsonnet_library of their file, as jsonnet_to_json rules do
+	r.SetPrivateAttr(jsonnetSelfPrivateAttr, map[string]fileinfo.FilePath{finfo.Path.Filename: finfo.Path})
+
+	return r
+}
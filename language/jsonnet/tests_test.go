@@ -0,0 +1,232 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTestRules(t *testing.T) {
+	testCases := []struct {
+		desc, directives, old, want string
+	}{
+		{
+			desc: "new",
+			want: `jsonnet_to_json_test(
+    name = "broken_error_test",
+    src = "broken_error.jsonnet",
+    error = 1,
+    golden = "broken_error.txt",
+    deps = ["//app:broken_error_library"],
+)
+
+jsonnet_to_json_test(
+    name = "main_test",
+    src = "main.jsonnet",
+    ext_strs = {
+        "env": "dev",
+    },
+    golden = "main.golden.json",
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json_test(
+    name = "stream_test",
+    src = "stream.jsonnet",
+    golden = "stream_test.golden.yaml",
+    yaml_stream = True,
+    deps = ["//app:stream_library"],
+)
+
+jsonnet_to_json_test(
+    name = "syntax_error_test",
+    src = "syntax_error.jsonnet",
+    error = 1,
+    golden = "syntax_error.txt",
+)
+`,
+		}, {
+			desc: "stale",
+			old: `jsonnet_to_json(
+    name = "broken_error_to_json",
+    src = "broken_error.jsonnet",
+    outs = ["broken_error.json"],
+)
+
+jsonnet_to_json_test(
+    name = "other_test",
+    src = "other.jsonnet",
+    golden = "other.golden.json",
+)
+
+jsonnet_to_json_test(
+    name = "other_custom_test",
+    src = "other.jsonnet",
+    golden = "custom.json",
+)
+`,
+			want: `jsonnet_to_json_test(
+    name = "other_custom_test",
+    src = "other.jsonnet",
+    golden = "custom.json",
+)
+
+jsonnet_to_json_test(
+    name = "broken_error_test",
+    src = "broken_error.jsonnet",
+    error = 1,
+    golden = "broken_error.txt",
+    deps = ["//app:broken_error_library"],
+)
+
+jsonnet_to_json_test(
+    name = "main_test",
+    src = "main.jsonnet",
+    ext_strs = {
+        "env": "dev",
+    },
+    golden = "main.golden.json",
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json_test(
+    name = "stream_test",
+    src = "stream.jsonnet",
+    golden = "stream_test.golden.yaml",
+    yaml_stream = True,
+    deps = ["//app:stream_library"],
+)
+
+jsonnet_to_json_test(
+    name = "syntax_error_test",
+    src = "syntax_error.jsonnet",
+    error = 1,
+    golden = "syntax_error.txt",
+)
+`,
+		}, {
+			desc: "variants",
+			directives: `# gazelle:jsonnet_variants env=dev,prod
+# gazelle:jsonnet_error_tests off
+`,
+			want: `jsonnet_to_json(
+    name = "broken_error_to_json",
+    src = "broken_error.jsonnet",
+    outs = ["broken_error.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:broken_error_library"],
+)
+
+jsonnet_to_json_test(
+    name = "main_prod_test",
+    src = "main.jsonnet",
+    ext_strs = {
+        "env": "prod",
+    },
+    golden = "main.prod.golden.json",
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json_test(
+    name = "stream_test",
+    src = "stream.jsonnet",
+    golden = "stream_test.golden.yaml",
+    yaml_stream = True,
+    deps = ["//app:stream_library"],
+)
+`,
+		}, {
+			desc:       "off",
+			directives: "# gazelle:jsonnet_golden_tests off\n",
+			old: `jsonnet_to_json_test(
+    name = "main_test",
+    src = "main.jsonnet",
+    golden = "main.golden.json",
+)
+`,
+			want: `jsonnet_to_json_test(
+    name = "broken_error_test",
+    src = "broken_error.jsonnet",
+    error = 1,
+    golden = "broken_error.txt",
+    deps = ["//app:broken_error_library"],
+)
+
+jsonnet_to_json_test(
+    name = "syntax_error_test",
+    src = "syntax_error.jsonnet",
+    error = 1,
+    golden = "syntax_error.txt",
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":            "{ env: std.extVar('env') }",
+		"app/main.golden.json":        `{"env": "dev"}`,
+		"app/main.prod.golden.json":   `{"env": "prod"}`,
+		"app/stream.jsonnet":          "std.manifestYamlStream([{}])",
+		"app/stream_test.golden.yaml": "--- {}",
+		"app/broken_error.jsonnet":    "error 'broken'",
+		"app/broken_error.txt":        "RUNTIME ERROR: broken",
+		// Files expected to fail may not parse
+		"app/syntax_error.jsonnet": "{ a: ",
+		"app/syntax_error.txt":     "STATIC ERROR: unexpected end of file",
+		"app/other.jsonnet":        "{}",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			directives := "# gazelle:jsonnet_ext_var env str dev\n" + tc.directives
+			f := update(t, root, "app", directives+tc.old)
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_to_json_test" && r.AttrString("src") != "broken_error.jsonnet" {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTestRulesErrorFiles(t *testing.T) {
+	old := `jsonnet_library(
+    name = "syntax_error_library",
+    srcs = ["syntax_error.jsonnet"],
+    visibility = ["//visibility:public"],
+)
+`
+	root := writeWorkspace(t, map[string]string{
+		"app/syntax_error.jsonnet": "{ a: ",
+		"app/syntax_error.txt":     "STATIC ERROR: unexpected end of file",
+		"app/var_error.jsonnet":    "error std.extVar('message')",
+		"app/var_error.txt":        "RUNTIME ERROR: broken",
+	})
+	defer os.RemoveAll(root)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	f := update(t, root, "app", old)
+
+	// The library of a file that no longer parses is deleted
+	for _, r := range f.Rules {
+		if r.Name() == "syntax_error_library" {
+			t.Errorf("got %s %s; want it deleted", r.Kind(), r.Name())
+		}
+	}
+	// Files expected to fail have no jsonnet_to_json rule reporting their variables
+	if want := `external variable "message" has no value to evaluate app/var_error.jsonnet`; !strings.Contains(logs.String(), want) {
+		t.Errorf("got logs:\n%s\nwant %q", logs.String(), want)
+	}
+}
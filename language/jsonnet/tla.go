@@ -0,0 +1,64 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+var (
+	// tlaAttrs are the attributes of jsonnet_to_json passing top-level arguments
+	tlaAttrs = []string{
+		"tla_strs",
+		"tla_code",
+		"tla_code_files",
+	}
+)
+
+// missingTLAs returns the parameters of a function without default value, nor
+// value set with the jsonnet_tla directive.
+func missingTLAs(conf *Config, fn *fileinfo.Function) []string {
+	var missing []string
+	for _, p := range fn.Params {
+		if _, found := conf.TLAs[p.Name]; !found && !p.HasDefault {
+			missing = append(missing, p.Name)
+		}
+	}
+	return missing
+}
+
+// setTLAAttrs sets the attributes of a jsonnet_to_json rule passing the values of
+// the parameters of the function its file evaluates to, if any. Values are only
+// given to the parameters of the function.
+func setTLAAttrs(conf *Config, r *rule.Rule, fn *fileinfo.Function) {
+	if fn == nil {
+		return
+	}
+	strs, code, codeFiles := map[string]string{}, map[string]string{}, map[string]string{}
+	for _, p := range fn.Params {
+		v, found := conf.TLAs[p.Name]
+		if !found {
+			continue
+		}
+		switch v.Kind {
+		case varStr:
+			strs[p.Name] = v.Value
+		case varCode:
+			code[p.Name] = v.Value
+		case varCodeFile:
+			// Code files are keyed by label
+			codeFiles[v.Value] = p.Name
+		}
+	}
+	for key, values := range map[string]map[string]string{
+		"tla_strs":       strs,
+		"tla_code":       code,
+		"tla_code_files": codeFiles,
+	} {
+		if len(values) > 0 {
+			r.SetAttr(key, values)
+		}
+	}
+}
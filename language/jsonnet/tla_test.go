@@ -0,0 +1,107 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTLAs(t *testing.T) {
+	testCases := []struct {
+		desc, directives, old, want string
+	}{
+		{
+			desc: "missing",
+			old: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+`,
+			want: "",
+		}, {
+			desc: "missing keep",
+			old: `# keep
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    tla_strs = {"env": "dev"},
+)
+`,
+			want: `# keep
+jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    tla_strs = {"env": "dev"},
+)
+`,
+		}, {
+			desc: "values",
+			directives: `# gazelle:jsonnet_tla env str dev
+# gazelle:jsonnet_tla config code_file //config:prod.libsonnet
+# gazelle:jsonnet_tla unused code 1
+`,
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    tla_code_files = {
+        "//config:prod.libsonnet": "config",
+    },
+    tla_strs = {
+        "env": "dev",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		}, {
+			desc: "defaults",
+			directives: `# gazelle:jsonnet_tla env str dev
+`,
+			old: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    tla_code = {"replicas": "1"},
+)
+`,
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    tla_strs = {
+        "env": "dev",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet": "function(env, config={}, replicas=1) { env: env, replicas: replicas } + config",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.directives+tc.old)
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_to_json" {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), tc.directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+const (
+	// variantsOff generates a single jsonnet_to_json rule per file
+	variantsOff = "off"
+)
+
+// Variants are the values of an external variable a jsonnet_to_json rule is
+// generated for, e.g. the environments a file is rendered for.
+type Variants struct {
+	Var    string   // Name of the external variable, e.g. env
+	Values []string // Values of the variable, e.g. dev, staging and prod
+}
+
+// setVariants sets the variants from a jsonnet_variants value: the name of an
+// external variable and its values, e.g. "env=dev,staging,prod", or off.
+func (conf *Config) setVariants(value string) error {
+	if value == variantsOff {
+		conf.Variants = nil
+		return nil
+	}
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("got %q: want <variable>=<value>,... or %s", value, variantsOff)
+	}
+	v := &Variants{Var: value[:i]}
+	seen := map[string]bool{}
+	for _, val := range strings.Split(value[i+1:], ",") {
+		if val == "" || strings.ContainsAny(val, "/: ") {
+			return fmt.Errorf("%q: want a variant usable in rule and file names", val)
+		}
+		if !seen[val] {
+			seen[val] = true
+			v.Values = append(v.Values, val)
+		}
+	}
+	conf.Variants = v
+	return nil
+}
+
+// forVariant returns the configuration of a variant, where its external
+// variable is set to the variant.
+func (conf *Config) forVariant(variant string) *Config {
+	cc := conf.clone()
+	cc.ExtVars[conf.Variants.Var] = VarValue{Kind: varStr, Value: variant}
+	return cc
+}
+
+// variantRuleName returns the name of the jsonnet_to_json rule of a variant,
+// e.g. main_prod_to_json.
+func variantRuleName(fpath fileinfo.FilePath, variant string) string {
+	if variant == "" {
+		return fpath.RuleName(toJSONRulePrefix)
+	}
+	return fileinfo.RuleName(fpath.Name+"_"+variant, toJSONRulePrefix)
+}
+
+// variantOf returns the variant a jsonnet_to_json rule of a build file was
+// generated for, if any: the rule has the file as src and is named after the
+// variant, see variantRuleName. Its outs are not relied on, as they may be set
+// with an output annotation or renamed to avoid a collision.
+func variantOf(r *rule.Rule, fpath fileinfo.FilePath) (string, bool) {
+	if r.Kind() != toJSONRule || r.AttrString("src") != fpath.Filename {
+		return "", false
+	}
+	name := r.Name()
+	prefix, suffix := fileinfo.RuleName(fpath.Name, ""), "_"+toJSONRulePrefix
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	variant := name[len(prefix) : len(name)-len(suffix)]
+	return variant, name == variantRuleName(fpath, variant)
+}
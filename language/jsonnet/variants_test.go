@@ -0,0 +1,211 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	testCases := []struct {
+		desc, directives, old, want string
+	}{
+		{
+			desc:       "new",
+			directives: "# gazelle:jsonnet_variants env=dev,prod\n",
+			want: `jsonnet_to_json(
+    name = "main_dev_to_json",
+    src = "main.jsonnet",
+    outs = ["main.dev.json"],
+    ext_strs = {
+        "env": "dev",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json(
+    name = "main_prod_to_json",
+    src = "main.jsonnet",
+    outs = ["main.prod.json"],
+    ext_strs = {
+        "env": "prod",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json(
+    name = "other_to_json",
+    src = "other.jsonnet",
+    outs = ["other.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:other_library"],
+)
+`,
+		}, {
+			desc:       "removed",
+			directives: "# gazelle:jsonnet_variants env=prod\n",
+			old: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+)
+
+jsonnet_to_json(
+    name = "main_staging_to_json",
+    src = "main.jsonnet",
+    outs = ["main.staging.json"],
+    ext_strs = {"env": "staging"},
+)
+
+# keep
+jsonnet_to_json(
+    name = "main_debug_to_json",
+    src = "main.jsonnet",
+    outs = ["debug.json"],
+)
+`,
+			want: `# keep
+jsonnet_to_json(
+    name = "main_debug_to_json",
+    src = "main.jsonnet",
+    outs = ["debug.json"],
+)
+
+jsonnet_to_json(
+    name = "main_prod_to_json",
+    src = "main.jsonnet",
+    outs = ["main.prod.json"],
+    ext_strs = {
+        "env": "prod",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json(
+    name = "other_to_json",
+    src = "other.jsonnet",
+    outs = ["other.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:other_library"],
+)
+`,
+		}, {
+			desc: "off",
+			directives: `# gazelle:jsonnet_variants off
+# gazelle:jsonnet_ext_var env str local
+`,
+			old: `jsonnet_to_json(
+    name = "main_prod_to_json",
+    src = "main.jsonnet",
+    outs = ["main.prod.json"],
+    ext_strs = {"env": "prod"},
+)
+`,
+			want: `jsonnet_to_json(
+    name = "main_to_json",
+    src = "main.jsonnet",
+    outs = ["main.json"],
+    ext_strs = {
+        "env": "local",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+
+jsonnet_to_json(
+    name = "other_to_json",
+    src = "other.jsonnet",
+    outs = ["other.json"],
+    visibility = ["//visibility:public"],
+    deps = ["//app:other_library"],
+)
+`,
+		},
+	}
+
+	root := writeWorkspace(t, map[string]string{
+		"app/main.jsonnet":  "(import 'env.libsonnet') + { replicas: 1 }",
+		"app/env.libsonnet": "{ env: std.extVar('env') }",
+		"app/other.jsonnet": "{}",
+	})
+	defer os.RemoveAll(root)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := update(t, root, "app", tc.directives+tc.old)
+			// Only compare the rules of the entrypoints
+			for _, r := range f.Rules {
+				if r.Kind() != "jsonnet_to_json" || strings.HasSuffix(r.AttrString("src"), ".libsonnet") {
+					r.Delete()
+				}
+			}
+			got := strings.TrimPrefix(string(f.Format()), tc.directives)
+			if strings.TrimSpace(got) != strings.TrimSpace(tc.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVariantsRemovedOutputs(t *testing.T) {
+	directives := "# gazelle:jsonnet_variants env=prod\n"
+	// The outs of the rules of removed variants are set with an annotation, or
+	// renamed to avoid a collision
+	old := `jsonnet_to_json(
+    name = "deploy_staging_to_json",
+    src = "deploy.jsonnet",
+    outs = ["deployment.staging.yaml"],
+)
+
+jsonnet_to_json(
+    name = "main_staging_to_json",
+    src = "main.jsonnet",
+    outs = ["main_1.staging.json"],
+)
+`
+	want := `jsonnet_to_json(
+    name = "deploy_prod_to_json",
+    src = "deploy.jsonnet",
+    outs = ["deployment.prod.yaml"],
+    ext_strs = {
+        "env": "prod",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:deploy_library"],
+)
+
+jsonnet_to_json(
+    name = "main_prod_to_json",
+    src = "main.jsonnet",
+    outs = ["main.prod.json"],
+    ext_strs = {
+        "env": "prod",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["//app:main_library"],
+)
+`
+
+	root := writeWorkspace(t, map[string]string{
+		"app/deploy.jsonnet": "// gazelle:output deployment.yaml\n{ env: std.extVar('env') }",
+		"app/main.jsonnet":   "{ env: std.extVar('env') }",
+	})
+	defer os.RemoveAll(root)
+
+	f := update(t, root, "app", directives+old)
+	for _, r := range f.Rules {
+		if r.Kind() != "jsonnet_to_json" {
+			r.Delete()
+		}
+	}
+	got := strings.TrimPrefix(string(f.Format()), directives)
+	if strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
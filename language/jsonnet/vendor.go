@@ -0,0 +1,139 @@
+// Copyright 2019 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package jsonnet
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/bundler"
+	"github.com/vmware/jsonnet-lang-for-gazelle/language/jsonnet/fileinfo"
+)
+
+// newVendorRules returns a jsonnet_library for each module vendored by
+// jsonnet-bundler. The libraries add the vendor directory to the library
+// paths, so the modules can be imported as jsonnet-bundler intends,
+// e.g. import 'github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet'.
+//
+// srcs contain the jsonnet files of the module and the files they importstr.
+// deps contain the libraries of the other modules they import.
+func (l *Lang) newVendorRules(c *config.Config, b *bundler.Bundle) []*rule.Rule {
+	var rules []*rule.Rule
+	for _, m := range b.Modules {
+		srcs, deps, err := l.scanModule(c, b, m)
+		if err != nil {
+			log.Printf("%s: %v", m.Dir, err)
+			continue
+		}
+
+		r := rule.NewRule(libraryRule, newModuleLabel(b, m).Name)
+		r.SetAttr("srcs", srcs)
+		r.SetAttr("imports", []string{"."})
+		if len(deps) > 0 {
+			labels := make([]string, len(deps))
+			for i, dep := range deps {
+				labels[i] = newModuleLabel(b, dep).String()
+			}
+			r.SetAttr("deps", labels)
+		}
+		r.SetAttr("visibility", []string{"//visibility:public"})
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// scanModule returns the sorted names of the files of a vendored module, i.e.
+// its jsonnet files and the files they importstr, within the vendor package.
+// It also returns the other modules they import, sorted by name.
+func (l *Lang) scanModule(c *config.Config, b *bundler.Bundle, m bundler.Module) ([]string, []bundler.Module, error) {
+	conf := GetConfig(c)
+	importer := &Importer{l.Importer}
+
+	srcs := map[string]bool{}
+	deps := map[string]bundler.Module{}
+	err := filepath.Walk(filepath.Join(c.RepoRoot, m.Dir), func(abs string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !conf.IsNativeImport(filepath.Ext(abs)) {
+			return err
+		}
+		srcs[vendorSrc(b, vendorRel(c, b, abs))] = true
+
+		imports, err := ParseFileImports(abs, importer)
+		if err != nil {
+			log.Printf("error parsing file %q: %v", abs, err)
+			return nil
+		}
+		for _, imp := range imports {
+			rel, found := resolveVendorImport(c, b, abs, imp.File)
+			if !found {
+				continue
+			}
+			if dep := b.Module(rel); dep != nil && dep.Dir != m.Dir {
+				deps[dep.Name] = *dep
+			} else if dep != nil && !conf.IsNativeImport(path.Ext(rel)) {
+				srcs[vendorSrc(b, rel)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	modules := make([]bundler.Module, 0, len(deps))
+	for _, dep := range deps {
+		modules = append(modules, dep)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+	return sortedKeys(srcs), modules, nil
+}
+
+// resolveVendorImport returns the workspace-relative path of a file imported
+// by a vendored file, looking it up next to the file and then in the vendor
+// directory.
+func resolveVendorImport(c *config.Config, b *bundler.Bundle, abs, importstr string) (string, bool) {
+	if candidate := filepath.Join(filepath.Dir(abs), importstr); exists(candidate) {
+		return vendorRel(c, b, candidate), true
+	}
+	if rel, found := b.ResolveImport(importstr); found && exists(filepath.Join(c.RepoRoot, rel)) {
+		return rel, true
+	}
+	return "", false
+}
+
+// vendorRel returns the workspace-relative path of a vendored file
+func vendorRel(c *config.Config, b *bundler.Bundle, abs string) string {
+	rel, _ := filepath.Rel(filepath.Join(c.RepoRoot, b.Vendor), abs)
+	return path.Join(b.Vendor, filepath.ToSlash(rel))
+}
+
+// vendorSrc returns the name of a vendored file within the vendor package
+func vendorSrc(b *bundler.Bundle, rel string) string {
+	return strings.TrimPrefix(rel, b.Vendor+"/")
+}
+
+func exists(abs string) bool {
+	_, err := os.Stat(abs)
+	return err == nil
+}
+
+// newModuleLabel returns the label of the library of a vendored module
+func newModuleLabel(b *bundler.Bundle, m bundler.Module) label.Label {
+	return label.New("", b.Vendor, fileinfo.RuleName(m.Name, libraryRulePrefix))
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}